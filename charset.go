@@ -0,0 +1,262 @@
+package simplexml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// charset converts between UTF-8 and a supported character encoding
+type charset struct {
+	// decode returns src converted to UTF-8
+	decode func(src []byte) ([]byte, error)
+
+	// encode appends r in the encoding to dst, or returns false if r can not be represented
+	encode func(dst []byte, r rune) ([]byte, bool)
+
+	// bom is written before any output
+	bom []byte
+}
+
+// windows1252 holds the characters of bytes 0x80 to 0x9F in Windows-1252, undefined bytes map to the same C1 control
+// character as ISO-8859-1
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// singleByte returns a charset for an encoding of one byte per character, table holds the character of each byte
+func singleByte(table func(b byte) (rune, bool)) *charset {
+	encoding := map[rune]byte{}
+	for i := 0; i < 256; i++ {
+		if r, ok := table(byte(i)); ok {
+			encoding[r] = byte(i)
+		}
+	}
+
+	return &charset{
+		decode: func(src []byte) ([]byte, error) {
+			dst := make([]byte, 0, len(src))
+			for i, c := range src {
+				r, ok := table(c)
+				if !ok {
+					return nil, fmt.Errorf("invalid byte 0x%02X at offset %d", c, i)
+				}
+				dst = utf8.AppendRune(dst, r)
+			}
+			return dst, nil
+		},
+		encode: func(dst []byte, r rune) ([]byte, bool) {
+			b, ok := encoding[r]
+			if !ok {
+				return dst, false
+			}
+			return append(dst, b), true
+		},
+	}
+}
+
+// utf16Charset returns a charset for UTF-16 in the given byte order. UTF-16 is written with a byte order mark.
+func utf16Charset(order binary.ByteOrder, bom []byte) *charset {
+	return &charset{
+		decode: func(src []byte) ([]byte, error) {
+			src = bytes.TrimPrefix(src, bom)
+			if len(src)%2 != 0 {
+				return nil, fmt.Errorf("odd number of bytes in UTF-16 input")
+			}
+
+			units := make([]uint16, len(src)/2)
+			for i := range units {
+				units[i] = order.Uint16(src[i*2:])
+			}
+
+			dst := make([]byte, 0, len(units))
+			for _, r := range utf16.Decode(units) {
+				dst = utf8.AppendRune(dst, r)
+			}
+			return dst, nil
+		},
+		encode: func(dst []byte, r rune) ([]byte, bool) {
+			var b [2]byte
+			if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+				order.PutUint16(b[:], uint16(r1))
+				dst = append(dst, b[:]...)
+				r = r2
+			}
+			order.PutUint16(b[:], uint16(r))
+			return append(dst, b[:]...), true
+		},
+		bom: bom,
+	}
+}
+
+var (
+	latin1Charset = singleByte(func(b byte) (rune, bool) {
+		return rune(b), true
+	})
+	asciiCharset = singleByte(func(b byte) (rune, bool) {
+		return rune(b), b < utf8.RuneSelf
+	})
+	windows1252Charset = singleByte(func(b byte) (rune, bool) {
+		if b >= 0x80 && b <= 0x9F {
+			return windows1252[b-0x80], true
+		}
+		return rune(b), true
+	})
+	utf16BECharset = utf16Charset(binary.BigEndian, []byte{0xFE, 0xFF})
+	utf16LECharset = utf16Charset(binary.LittleEndian, []byte{0xFF, 0xFE})
+)
+
+// lookupCharset returns the charset of an encoding name (not case sensitive) and nil for UTF-8, which needs no
+// conversion. An error is returned for an unsupported encoding.
+func lookupCharset(name string) (*charset, error) {
+	switch strings.ToLower(name) {
+	case "", "utf-8", "utf8":
+		return nil, nil
+	case "iso-8859-1", "iso8859-1", "iso_8859-1", "latin1", "l1":
+		return latin1Charset, nil
+	case "us-ascii", "ascii":
+		return asciiCharset, nil
+	case "windows-1252", "cp1252":
+		return windows1252Charset, nil
+	case "utf-16", "utf-16be":
+		return utf16BECharset, nil
+	case "utf-16le":
+		return utf16LECharset, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding '%s'", name)
+}
+
+// decodeCharset converts src to UTF-8. The encoding is detected from a byte order mark or the first characters of
+// UTF-16 input, and otherwise taken from the encoding of the XML declaration. Encodings that are not supported are
+// passed to charsetReader, if set.
+func decodeCharset(src []byte, charsetReader func(charset string, input io.Reader) (io.Reader, error)) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(src, []byte{0xEF, 0xBB, 0xBF}):
+		return src[3:], nil
+	case bytes.HasPrefix(src, []byte{0xFE, 0xFF}), bytes.HasPrefix(src, []byte{0x00, '<', 0x00, '?'}):
+		return utf16BECharset.decode(src)
+	case bytes.HasPrefix(src, []byte{0xFF, 0xFE}), bytes.HasPrefix(src, []byte{'<', 0x00, '?', 0x00}):
+		return utf16LECharset.decode(src)
+	}
+
+	// the declaration is ASCII in every other supported encoding
+	name := ""
+	if bytes.HasPrefix(src, []byte("<?xml")) {
+		if end := bytes.Index(src, []byte("?>")); end > 0 {
+			if decl, err := parseDeclaration(string(src[5:end])); err == nil {
+				name = decl.Encoding
+			}
+		}
+	}
+
+	cs, err := lookupCharset(name)
+	if err == nil {
+		if cs == nil {
+			return src, nil
+		}
+		return cs.decode(src)
+	}
+
+	if charsetReader == nil {
+		return nil, err
+	}
+
+	r, err := charsetReader(name, bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// charsetWriter converts the UTF-8 written to it into a charset, runes that the charset can not represent are
+// written as character references. checkMarkup is used first to ensure there are none outside of text.
+type charsetWriter struct {
+	w       io.Writer
+	cs      *charset
+	buf     []byte
+	pending []byte
+	started bool
+}
+
+func (c *charsetWriter) Write(p []byte) (int, error) {
+	out := c.buf[:0]
+	if !c.started {
+		out = append(out, c.cs.bom...)
+		c.started = true
+	}
+
+	// a rune may be split across writes, keep an incomplete rune until the next write
+	data := p
+	if len(c.pending) > 0 {
+		data = append(c.pending, p...)
+	}
+
+	for len(data) > 0 && utf8.FullRune(data) {
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+
+		var ok bool
+		if out, ok = c.cs.encode(out, r); !ok {
+			ref := strconv.AppendInt([]byte("&#x"), int64(r), 16)
+			for _, b := range append(ref, ';') {
+				out, _ = c.cs.encode(out, rune(b))
+			}
+		}
+	}
+	c.pending = append([]byte{}, data...)
+	c.buf = out
+
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// checkMarkup returns an error for the first rune within elements that the charset can not represent outside of text
+// and attribute values. Character references are only read as characters there, elsewhere they would be written as
+// literal text (in CDATA, Comments and processing instructions) or make the document malformed (in names).
+func (c *charset) checkMarkup(elements []Element, path XPath, encoding string) error {
+	for _, v := range elements {
+		var markup []string
+		elementPath := path
+		switch k := v.(type) {
+		case *Tag:
+			elementPath = append(path[:len(path):len(path)], k.qualifiedName())
+			markup = append(markup, k.Prefix, k.Name)
+			for _, attr := range k.Attributes {
+				markup = append(markup, attr.Prefix, attr.Name)
+			}
+		case *CDATA:
+			markup = append(markup, string(*k))
+		case *Comment:
+			markup = append(markup, string(*k))
+		case *ProcInst:
+			markup = append(markup, k.Target, k.Data)
+		case *EntityRef:
+			markup = append(markup, string(*k))
+		case *Doctype:
+			markup = append(markup, k.Name, k.PublicID, k.SystemID, k.InternalSubset)
+		}
+
+		for _, s := range markup {
+			for _, r := range s {
+				if _, ok := c.encode(nil, r); !ok {
+					return &ValidationError{Path: elementPath, Msg: fmt.Sprintf("character %U can not be written in %s outside of text", r, encoding)}
+				}
+			}
+		}
+
+		if t, ok := v.(*Tag); ok {
+			if err := c.checkMarkup(t.elements, elementPath, encoding); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
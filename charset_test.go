@@ -0,0 +1,159 @@
+package simplexml
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+func TestCharset(t *testing.T) {
+	Convey("Given an ISO-8859-1 document", t, func() {
+		x := "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><root>caf\xe9</root>"
+		d, err := NewDocumentFromReader(strings.NewReader(x))
+		So(err, ShouldBeNil)
+
+		Convey("Values should be decoded to UTF-8", func() {
+			v, err := d.Root().Value()
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, "café")
+		})
+
+		Convey("Marshal should write the encoding of the Declaration", func() {
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, x)
+		})
+
+		Convey("Characters outside of the encoding should be written as character references", func() {
+			d.Root().AddAfter(NewValue(" €"), nil)
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><root>caf\xe9 &#x20ac;</root>")
+		})
+
+		Convey("Characters outside of the encoding and outside of text should return an error", func() {
+			d.Root().AddBefore(NewComment("€"), nil)
+			_, err := d.Marshal()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "/root: character U+20AC can not be written in ISO-8859-1 outside of text")
+
+			_, err = d.MarshalWithOptions(MarshalOptions{Encoding: "UTF-8"})
+			So(err, ShouldBeNil)
+
+			var w bytes.Buffer
+			_, err = d.WriteTo(&w)
+			So(err, ShouldNotBeNil)
+			So(w.Len(), ShouldEqual, 0)
+		})
+
+		Convey("Characters outside of the encoding in names should return an error", func() {
+			d.Root().AddAfter(NewTag("prix€"), nil)
+			_, err := d.MarshalWithOptions(MarshalOptions{Encoding: "US-ASCII"})
+			So(err, ShouldNotBeNil)
+
+			d.Root().Tags()[0].Name = "price"
+			d.Root().Attributes = append(d.Root().Attributes, &Attribute{Name: "café", Value: "€"})
+			_, err = d.Marshal()
+			So(err, ShouldBeNil)
+			_, err = d.MarshalWithOptions(MarshalOptions{Encoding: "US-ASCII"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The Encoding option should replace the encoding of the output and Declaration", func() {
+			b, err := d.MarshalWithOptions(MarshalOptions{Encoding: "UTF-8"})
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `<?xml version="1.0" encoding="UTF-8"?><root>café</root>`)
+
+			b, err = d.MarshalWithOptions(MarshalOptions{Encoding: "US-ASCII", OmitDeclaration: true})
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "<root>caf&#xe9;</root>")
+		})
+
+		Convey("An unsupported Encoding should return an error", func() {
+			_, err := d.MarshalWithOptions(MarshalOptions{Encoding: "EBCDIC"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a Windows-1252 document", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader("<?xml version=\"1.0\" encoding=\"windows-1252\"?><root>\x93quoted\x94 \x80</root>"))
+		So(err, ShouldBeNil)
+
+		Convey("Values should be decoded to UTF-8", func() {
+			v, err := d.Root().Value()
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, "“quoted” €")
+		})
+	})
+
+	Convey("Given a UTF-16 document with a little endian byte order mark", t, func() {
+		var b bytes.Buffer
+		b.Write([]byte{0xFF, 0xFE})
+		for _, u := range utf16.Encode([]rune(`<?xml version="1.0" encoding="UTF-16"?><root>日本</root>`)) {
+			b.Write([]byte{byte(u), byte(u >> 8)})
+		}
+
+		d, err := NewDocumentFromReader(&b)
+		So(err, ShouldBeNil)
+
+		Convey("Values should be decoded to UTF-8", func() {
+			v, err := d.Root().Value()
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, "日本")
+		})
+
+		Convey("Marshal should write UTF-16 with a byte order mark", func() {
+			out, err := d.MarshalWithOptions(MarshalOptions{OmitDeclaration: true})
+			So(err, ShouldBeNil)
+			So(out, ShouldResemble, []byte{0xFE, 0xFF, 0, '<', 0, 'r', 0, 'o', 0, 'o', 0, 't', 0, '>', 0x65, 0xE5, 0x67, 0x2C, 0, '<', 0, '/', 0, 'r', 0, 'o', 0, 'o', 0, 't', 0, '>'})
+		})
+	})
+
+	Convey("Given a document in an encoding that is not built in", t, func() {
+		x := `<?xml version="1.0" encoding="x-upper"?><root>abc</root>`
+
+		Convey("NewDocumentFromReader should return an error", func() {
+			_, err := NewDocumentFromReader(strings.NewReader(x))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A CharsetReader should be used to decode it", func() {
+			var name string
+			d, err := NewDocumentFromReaderWithOptions(strings.NewReader(x), ParseOptions{
+				CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+					name = charset
+					return input, nil
+				},
+			})
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "x-upper")
+			So(d.Root().Name, ShouldEqual, "root")
+		})
+
+		Convey("A document read through a CharsetReader should be written as UTF-8", func() {
+			d, err := NewDocumentFromReaderWithOptions(strings.NewReader(x), ParseOptions{
+				CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+					return input, nil
+				},
+			})
+			So(err, ShouldBeNil)
+
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `<?xml version="1.0" encoding="UTF-8"?><root>abc</root>`)
+
+			var w bytes.Buffer
+			_, err = d.WriteTo(&w)
+			So(err, ShouldBeNil)
+			So(w.String(), ShouldEqual, string(b))
+			So(d.Declaration.Encoding, ShouldEqual, "x-upper")
+
+			_, err = d.MarshalWithOptions(MarshalOptions{Encoding: "x-upper"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,786 @@
+package simplexml
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Selector is a compiled CSS selector that can be matched against any number of Tags and Documents.
+//
+// Supported are type selectors ('item', '*' and 'prefix|item'), '#id' and '.class' (matching the 'id' and 'class'
+// Attributes), attribute selectors ('[a]', '[a=v]', '[a~=v]', '[a|=v]', '[a^=v]', '[a$=v]' and '[a*=v]', with an
+// optional 'i' flag for case insensitive values), the descendant (' '), child ('>'), adjacent sibling ('+') and
+// general sibling ('~') combinators, selector lists (',') and the pseudo-classes :root, :scope, :empty,
+// :first-child, :last-child, :only-child, :first-of-type, :last-of-type, :only-of-type, :nth-child(),
+// :nth-last-child(), :nth-of-type(), :nth-last-of-type() and :not(). The extensions :name-fold(name),
+// :name-regexp(expr) and :name-glob(pattern) match the local name of elements like Search.ByNameFold,
+// Search.ByNameRegexp and Search.ByNameGlob. Their argument may be quoted and must be if it contains ')'.
+//
+// Type selectors without a prefix match on local name and ignore the namespace of the element, like Search.ByName.
+// A prefix is resolved through GetNamespace of the Tag the selector is matched from and matches elements whose
+// prefix resolves to the same namespace, falling back to a comparison of the literal prefix when the namespace is
+// not available. Attribute names are matched as written, including their prefix (eg. '[xml:lang]').
+type Selector struct {
+	selector string
+	list     cssList
+}
+
+// CompileSelector parses a CSS selector and returns a Selector that can be used to match it.
+func CompileSelector(selector string) (*Selector, error) {
+	p := &cssParser{s: selector}
+	list, err := p.parseList(false)
+	if err != nil {
+		return nil, err
+	}
+	return &Selector{selector: selector, list: list}, nil
+}
+
+// MustCompileSelector is like CompileSelector but panics if the selector cannot be parsed.
+func MustCompileSelector(selector string) *Selector {
+	s, err := CompileSelector(selector)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// String returns the source text of the selector
+func (s *Selector) String() string {
+	return s.selector
+}
+
+// Match returns true if t matches the selector.
+func (s *Selector) Match(t *Tag) bool {
+	return s.list.matches(t, nil)
+}
+
+// Select returns the Tags below t that match the selector in document order. As with querySelectorAll in the DOM,
+// combinators may match the ancestors of t, while :scope only matches t itself.
+func (s *Selector) Select(t *Tag) Search {
+	return Search{t}.Descendants().Filter(func(c *Tag) bool {
+		return s.list.matches(c, t)
+	})
+}
+
+// selectDocument returns the Tags of d that match the selector in document order. The root is the scope, so prefixes
+// are resolved through its namespaces as they are by Select.
+func (s *Selector) selectDocument(d *Document) Search {
+	var r Search
+	for _, e := range d.elements {
+		if t, ok := e.(*Tag); ok {
+			if s.list.matches(t, t) {
+				r = append(r, t)
+			}
+			r = append(r, s.Select(t)...)
+		}
+	}
+	return r
+}
+
+// Select compiles selector and returns the Tags below the current Tag that match it.
+func (t *Tag) Select(selector string) (Search, error) {
+	s, err := CompileSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return s.Select(t), nil
+}
+
+// SelectOne is like Select, but returns the first Tag in document order or nil if none match.
+func (t *Tag) SelectOne(selector string) (*Tag, error) {
+	s, err := t.Select(selector)
+	if err != nil {
+		return nil, err
+	}
+	return s.One(), nil
+}
+
+// Select compiles selector and returns the Tags of the document that match it.
+func (d *Document) Select(selector string) (Search, error) {
+	s, err := CompileSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return s.selectDocument(d), nil
+}
+
+// SelectOne is like Select, but returns the first Tag in document order or nil if none match.
+func (d *Document) SelectOne(selector string) (*Tag, error) {
+	s, err := d.Select(selector)
+	if err != nil {
+		return nil, err
+	}
+	return s.One(), nil
+}
+
+// SelectorError is returned when a CSS selector can not be compiled
+type SelectorError struct {
+	// Selector is the source text of the selector
+	Selector string
+
+	// Offset is the byte offset in Selector at which the error was found
+	Offset int
+
+	// Msg describes the error
+	Msg string
+}
+
+// Error implements the error interface
+func (e *SelectorError) Error() string {
+	return fmt.Sprintf("selector '%s' at offset %d: %s", e.Selector, e.Offset, e.Msg)
+}
+
+/*
+	Matching
+*/
+
+// cssTest is a simple selector, scope is the Tag the selector is matched from or nil
+type cssTest func(t *Tag, scope *Tag) bool
+
+// cssCompound is a sequence of simple selectors that must all match
+type cssCompound []cssTest
+
+func (c cssCompound) matches(t *Tag, scope *Tag) bool {
+	for _, test := range c {
+		if !test(t, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+type cssCombinator int
+
+const (
+	cssDescendant cssCombinator = iota
+	cssChild
+	cssAdjacent
+	cssSibling
+)
+
+// cssComplex is a sequence of compound selectors, combinators[i] relates compounds[i] and compounds[i+1]
+type cssComplex struct {
+	compounds   []cssCompound
+	combinators []cssCombinator
+}
+
+// matches matches the compound selectors from right to left
+func (c cssComplex) matches(t *Tag, scope *Tag) bool {
+	return c.matchAt(len(c.compounds)-1, t, scope)
+}
+
+func (c cssComplex) matchAt(i int, t *Tag, scope *Tag) bool {
+	if !c.compounds[i].matches(t, scope) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+
+	switch c.combinators[i-1] {
+	case cssChild:
+		return t.parent != nil && c.matchAt(i-1, t.parent, scope)
+	case cssDescendant:
+		for p := t.parent; p != nil; p = p.parent {
+			if c.matchAt(i-1, p, scope) {
+				return true
+			}
+		}
+	case cssAdjacent:
+		siblings := cssSiblings(t)
+		if k := tagIndex(siblings, t); k > 0 {
+			return c.matchAt(i-1, siblings[k-1].(*Tag), scope)
+		}
+	case cssSibling:
+		siblings := cssSiblings(t)
+		for k := tagIndex(siblings, t) - 1; k >= 0; k-- {
+			if c.matchAt(i-1, siblings[k].(*Tag), scope) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cssList is a selector list, matching if any of its selectors match
+type cssList []cssComplex
+
+func (l cssList) matches(t *Tag, scope *Tag) bool {
+	for _, c := range l {
+		if c.matches(t, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// cssSiblings returns the Tags that share a parent (or Document) with t, including t, as Elements
+func cssSiblings(t *Tag) []Element {
+	var elements []Element
+	switch {
+	case t.parent != nil:
+		elements = t.parent.elements
+	case t.document != nil:
+		elements = t.document.elements
+	default:
+		return []Element{t}
+	}
+
+	var r []Element
+	for _, e := range elements {
+		if _, ok := e.(*Tag); ok {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// cssPosition returns the 1 based position of t among its sibling Tags (of the same name if ofType), counted from
+// the end if last, and the number of those siblings
+func cssPosition(t *Tag, ofType bool, last bool) (int, int) {
+	var position, size int
+	for _, e := range cssSiblings(t) {
+		s := e.(*Tag)
+		if ofType && (s.Name != t.Name || s.Prefix != t.Prefix) {
+			continue
+		}
+		size++
+		if s == t {
+			position = size
+		}
+	}
+
+	if last {
+		position = size - position + 1
+	}
+	return position, size
+}
+
+// cssNth matches positions of the form an+b for some n >= 0
+type cssNth struct {
+	a, b int
+}
+
+func (n cssNth) matches(position int) bool {
+	if n.a == 0 {
+		return position == n.b
+	}
+	d := position - n.b
+	return d%n.a == 0 && d/n.a >= 0
+}
+
+/*
+	Parser
+*/
+
+type cssParser struct {
+	s   string
+	pos int
+}
+
+func (p *cssParser) errorf(format string, a ...interface{}) error {
+	return &SelectorError{Selector: p.s, Offset: p.pos, Msg: fmt.Sprintf(format, a...)}
+}
+
+// peek returns the next byte or 0 at the end of the selector
+func (p *cssParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+// skipSpace skips whitespace and returns true if there was any
+func (p *cssParser) skipSpace() bool {
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n\f", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// ident reads a name, which unlike an XML name may not contain '.'
+func (p *cssParser) ident() (string, error) {
+	start := p.pos
+	for p.pos < len(p.s) {
+		r, n := utf8.DecodeRuneInString(p.s[p.pos:])
+		if r == '.' || !isNameChar(r) || p.pos == start && !isNameStart(r) {
+			break
+		}
+		p.pos += n
+	}
+
+	if p.pos == start {
+		return "", p.errorf("expected a name")
+	}
+	return p.s[start:p.pos], nil
+}
+
+// parseList reads a comma separated selector list, up to a ')' when nested
+func (p *cssParser) parseList(nested bool) (cssList, error) {
+	var list cssList
+
+	for {
+		p.skipSpace()
+		c, err := p.parseComplex()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+
+		p.skipSpace()
+		switch {
+		case p.peek() == ',':
+			p.pos++
+		case nested && p.peek() == ')':
+			return list, nil
+		case !nested && p.pos == len(p.s):
+			return list, nil
+		default:
+			return nil, p.errorf("unexpected '%c'", p.peek())
+		}
+	}
+}
+
+// parseComplex reads compound selectors separated by combinators
+func (p *cssParser) parseComplex() (cssComplex, error) {
+	var c cssComplex
+
+	for {
+		compound, err := p.parseCompound()
+		if err != nil {
+			return c, err
+		}
+		c.compounds = append(c.compounds, compound)
+
+		space := p.skipSpace()
+		combinator := cssDescendant
+		switch p.peek() {
+		case 0, ',', ')':
+			return c, nil
+		case '>':
+			combinator = cssChild
+		case '+':
+			combinator = cssAdjacent
+		case '~':
+			combinator = cssSibling
+		default:
+			if !space {
+				return c, p.errorf("unexpected '%c'", p.peek())
+			}
+		}
+
+		if combinator != cssDescendant {
+			p.pos++
+			p.skipSpace()
+		}
+		c.combinators = append(c.combinators, combinator)
+	}
+}
+
+// parseCompound reads an optional type selector followed by any number of id, class, attribute and pseudo-class
+// selectors
+func (p *cssParser) parseCompound() (cssCompound, error) {
+	var c cssCompound
+
+	if r, _ := utf8.DecodeRuneInString(p.s[p.pos:]); r == '*' || r == '|' || isNameStart(r) {
+		test, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, test)
+	}
+
+	for {
+		var test cssTest
+		var err error
+
+		switch p.peek() {
+		case '#':
+			p.pos++
+			var id string
+			if id, err = p.ident(); err == nil {
+				test = attributeTest("id", "=", id, false)
+			}
+		case '.':
+			p.pos++
+			var class string
+			if class, err = p.ident(); err == nil {
+				test = attributeTest("class", "~=", class, false)
+			}
+		case '[':
+			test, err = p.parseAttribute()
+		case ':':
+			test, err = p.parsePseudo()
+		default:
+			if len(c) == 0 {
+				return nil, p.errorf("expected a selector")
+			}
+			return c, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, test)
+	}
+}
+
+// parseType reads 'name', '*', 'prefix|name', '*|name' or '|name'
+func (p *cssParser) parseType() (cssTest, error) {
+	name := func() (string, error) {
+		if p.peek() == '*' {
+			p.pos++
+			return "*", nil
+		}
+		return p.ident()
+	}
+
+	prefix, local := "*", ""
+	var err error
+	if p.peek() == '|' {
+		prefix = ""
+	} else if local, err = name(); err != nil {
+		return nil, err
+	}
+
+	if p.peek() == '|' {
+		if local != "" {
+			prefix = local
+		}
+		p.pos++
+		if local, err = name(); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(t *Tag, scope *Tag) bool {
+		if local != "*" && t.Name != local {
+			return false
+		}
+
+		switch prefix {
+		case "*":
+			return true
+		case "":
+			return t.Prefix == ""
+		}
+
+		if scope == nil {
+			return t.Prefix == prefix
+		}
+		ns, err := scope.GetNamespace(prefix)
+		if err != nil {
+			return t.Prefix == prefix
+		}
+		tagNS, err := t.GetNamespace(t.Prefix)
+		return err == nil && tagNS == ns
+	}, nil
+}
+
+// parseAttribute reads '[name]' or '[name op value flag]'
+func (p *cssParser) parseAttribute() (cssTest, error) {
+	p.pos++
+	p.skipSpace()
+
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	// both 'prefix|name' and 'prefix:name' refer to the Attribute as written
+	if c := p.peek(); (c == '|' || c == ':') && !strings.HasPrefix(p.s[p.pos:], "|=") {
+		p.pos++
+		local, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		name += ":" + local
+	}
+	p.skipSpace()
+
+	if p.peek() == ']' {
+		p.pos++
+		return func(t *Tag, scope *Tag) bool {
+			return t.HasAttr(name)
+		}, nil
+	}
+
+	op := ""
+	for _, o := range []string{"=", "~=", "|=", "^=", "$=", "*="} {
+		if strings.HasPrefix(p.s[p.pos:], o) {
+			op = o
+		}
+	}
+	if op == "" {
+		return nil, p.errorf("expected an attribute operator")
+	}
+	p.pos += len(op)
+	p.skipSpace()
+
+	var value string
+	if q := p.peek(); q == '"' || q == '\'' {
+		value, err = p.parseArgument()
+	} else {
+		value, err = p.ident()
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+
+	fold := false
+	switch p.peek() {
+	case 'i', 'I':
+		fold = true
+		p.pos++
+		p.skipSpace()
+	case 's', 'S':
+		p.pos++
+		p.skipSpace()
+	}
+
+	if p.peek() != ']' {
+		return nil, p.errorf("expected ']'")
+	}
+	p.pos++
+
+	return attributeTest(name, op, value, fold), nil
+}
+
+// attributeTest returns a test comparing the value of the named Attribute with op
+func attributeTest(name, op, value string, fold bool) cssTest {
+	if fold {
+		value = strings.ToLower(value)
+	}
+
+	return func(t *Tag, scope *Tag) bool {
+		attr := t.Attr(name)
+		if attr == nil {
+			return false
+		}
+
+		v := attr.Value
+		if fold {
+			v = strings.ToLower(v)
+		}
+
+		switch op {
+		case "=":
+			return v == value
+		case "~=":
+			for _, f := range strings.Fields(v) {
+				if f == value {
+					return true
+				}
+			}
+			return false
+		case "|=":
+			return v == value || strings.HasPrefix(v, value+"-")
+		case "^=":
+			return value != "" && strings.HasPrefix(v, value)
+		case "$=":
+			return value != "" && strings.HasSuffix(v, value)
+		}
+		return value != "" && strings.Contains(v, value)
+	}
+}
+
+// parsePseudo reads a pseudo-class
+func (p *cssParser) parsePseudo() (cssTest, error) {
+	p.pos++
+	start := p.pos
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+
+	position := func(ofType, last bool, nth cssNth) cssTest {
+		return func(t *Tag, scope *Tag) bool {
+			position, _ := cssPosition(t, ofType, last)
+			return nth.matches(position)
+		}
+	}
+	only := func(ofType bool) cssTest {
+		return func(t *Tag, scope *Tag) bool {
+			_, size := cssPosition(t, ofType, false)
+			return size == 1
+		}
+	}
+
+	switch name {
+	case "root":
+		return func(t *Tag, scope *Tag) bool {
+			return t.parent == nil
+		}, nil
+	case "scope":
+		return func(t *Tag, scope *Tag) bool {
+			if scope == nil {
+				return t.parent == nil
+			}
+			return t == scope
+		}, nil
+	case "empty":
+		return func(t *Tag, scope *Tag) bool {
+			for _, e := range t.elements {
+				switch v := e.(type) {
+				case *Comment, *ProcInst:
+				case *Value:
+					if *v != "" {
+						return false
+					}
+				default:
+					return false
+				}
+			}
+			return true
+		}, nil
+	case "first-child":
+		return position(false, false, cssNth{b: 1}), nil
+	case "last-child":
+		return position(false, true, cssNth{b: 1}), nil
+	case "only-child":
+		return only(false), nil
+	case "first-of-type":
+		return position(true, false, cssNth{b: 1}), nil
+	case "last-of-type":
+		return position(true, true, cssNth{b: 1}), nil
+	case "only-of-type":
+		return only(true), nil
+	}
+
+	if p.peek() != '(' {
+		p.pos = start
+		return nil, p.errorf("unknown pseudo-class ':%s'", name)
+	}
+	p.pos++
+	p.skipSpace()
+
+	var test cssTest
+	switch name {
+	case "nth-child", "nth-last-child", "nth-of-type", "nth-last-of-type":
+		nth, err := p.parseNth()
+		if err != nil {
+			return nil, err
+		}
+		test = position(strings.HasSuffix(name, "of-type"), strings.HasPrefix(name, "nth-last"), nth)
+	case "not":
+		list, err := p.parseList(true)
+		if err != nil {
+			return nil, err
+		}
+		test = func(t *Tag, scope *Tag) bool {
+			return !list.matches(t, scope)
+		}
+	case "name-fold", "name-regexp", "name-glob":
+		arg, err := p.parseArgument()
+		if err != nil {
+			return nil, err
+		}
+		match := func(name string) bool { return strings.EqualFold(name, arg) }
+		switch name {
+		case "name-regexp":
+			re, err := regexp.Compile(arg)
+			if err != nil {
+				return nil, p.errorf("invalid regular expression '%s'", arg)
+			}
+			match = re.MatchString
+		case "name-glob":
+			if _, err := path.Match(arg, ""); err != nil {
+				return nil, p.errorf("invalid pattern '%s'", arg)
+			}
+			match = func(name string) bool { return matchGlob(arg, name) }
+		}
+		test = func(t *Tag, scope *Tag) bool {
+			return match(t.Name)
+		}
+	default:
+		p.pos = start
+		return nil, p.errorf("unknown pseudo-class ':%s()'", name)
+	}
+
+	p.skipSpace()
+	if p.peek() != ')' {
+		return nil, p.errorf("expected ')'")
+	}
+	p.pos++
+	return test, nil
+}
+
+// parseArgument reads a quoted string or the text up to the closing ')'
+func (p *cssParser) parseArgument() (string, error) {
+	if q := p.peek(); q == '"' || q == '\'' {
+		end := strings.IndexByte(p.s[p.pos+1:], q)
+		if end < 0 {
+			return "", p.errorf("unterminated string")
+		}
+		arg := p.s[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return arg, nil
+	}
+
+	end := strings.IndexByte(p.s[p.pos:], ')')
+	if end < 0 {
+		return "", p.errorf("expected ')'")
+	}
+	arg := strings.TrimSpace(p.s[p.pos : p.pos+end])
+	if arg == "" {
+		return "", p.errorf("expected an argument")
+	}
+	p.pos += end
+	return arg, nil
+}
+
+// parseNth reads the an+b argument of the :nth-* pseudo-classes, including 'odd' and 'even'
+func (p *cssParser) parseNth() (cssNth, error) {
+	end := strings.IndexByte(p.s[p.pos:], ')')
+	if end < 0 {
+		return cssNth{}, p.errorf("expected ')'")
+	}
+
+	arg := strings.ToLower(strings.Join(strings.Fields(p.s[p.pos:p.pos+end]), ""))
+	invalid := p.errorf("invalid argument '%s'", p.s[p.pos:p.pos+end])
+	p.pos += end
+
+	switch arg {
+	case "odd":
+		return cssNth{a: 2, b: 1}, nil
+	case "even":
+		return cssNth{a: 2}, nil
+	}
+
+	var nth cssNth
+	var err error
+	i := strings.IndexByte(arg, 'n')
+	if i < 0 {
+		nth.b, err = strconv.Atoi(arg)
+		if err != nil {
+			return nth, invalid
+		}
+		return nth, nil
+	}
+
+	switch a := arg[:i]; a {
+	case "", "+":
+		nth.a = 1
+	case "-":
+		nth.a = -1
+	default:
+		if nth.a, err = strconv.Atoi(a); err != nil {
+			return nth, invalid
+		}
+	}
+
+	if b := arg[i+1:]; b != "" {
+		if b[0] != '+' && b[0] != '-' {
+			return nth, invalid
+		}
+		if nth.b, err = strconv.Atoi(b); err != nil {
+			return nth, invalid
+		}
+	}
+	return nth, nil
+}
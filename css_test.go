@@ -0,0 +1,172 @@
+package simplexml
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+
+	"strings"
+)
+
+func TestSelect(t *testing.T) {
+	Convey("Given a document with a list of items", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(`<root xmlns:m="urn:media">` +
+			`<item type="video" id="a"><title>A</title></item>` +
+			`<item type="video" id="b" class="new hot"><title>B</title><m:title>b</m:title></item>` +
+			`<note/>` +
+			`<item type="audio" id="c" lang="en-US"><title>C</title></item>` +
+			`</root>`))
+		So(err, ShouldBeNil)
+		root := d.Root()
+
+		values := func(s Search) []string {
+			var r []string
+			for _, t := range s {
+				if id := t.Attr("id"); id != nil {
+					r = append(r, id.Value)
+				} else {
+					v, _ := t.Value()
+					r = append(r, v)
+				}
+			}
+			return r
+		}
+
+		Convey("Type selectors and combinators should match through the tree", func() {
+			s, err := root.Select("root > item[type=video]:nth-child(2) title")
+			So(err, ShouldBeNil)
+			So(values(s), ShouldResemble, []string{"B", "b"})
+
+			s, err = d.Select("item + note ~ item")
+			So(err, ShouldBeNil)
+			So(values(s), ShouldResemble, []string{"c"})
+
+			s, err = d.Select("note + item, item:first-child")
+			So(err, ShouldBeNil)
+			So(values(s), ShouldResemble, []string{"a", "c"})
+		})
+
+		Convey("Attribute selectors should compare values", func() {
+			for selector, expected := range map[string][]string{
+				"[class]":         {"b"},
+				"[type^=vid]":     {"a", "b"},
+				"[type*=udi]":     {"c"},
+				"[type$='eo']":    {"a", "b"},
+				"[class~=hot]":    {"b"},
+				"[lang|=en]":      {"c"},
+				"[type=VIDEO i]":  {"a", "b"},
+				"#c":              {"c"},
+				".new":            {"b"},
+				"item:not(#a,#c)": {"b"},
+			} {
+				s, err := root.Select(selector)
+				So(err, ShouldBeNil)
+				So(values(s), ShouldResemble, expected)
+			}
+		})
+
+		Convey("Structural pseudo-classes should count sibling Tags", func() {
+			for selector, expected := range map[string][]string{
+				"item:nth-child(even)":      {"b", "c"},
+				"item:nth-of-type(2n+1)":    {"a", "c"},
+				"item:nth-last-of-type(1)":  {"c"},
+				":nth-last-child(-n+2)":     {"A", "B", "b", "", "c", "C"},
+				"note:only-of-type":         {""},
+				"title:last-child":          {"A", "b", "C"},
+				"title:first-of-type":       {"A", "B", "b", "C"},
+				"title:only-child":          {"A", "C"},
+				"item > :last-of-type":      {"A", "B", "b", "C"},
+				"note:empty, :scope > note": {""},
+			} {
+				s, err := root.Select(selector)
+				So(err, ShouldBeNil)
+				So(values(s), ShouldResemble, expected)
+			}
+		})
+
+		Convey("Namespace prefixes should be resolved", func() {
+			s, err := root.Select("m|title")
+			So(err, ShouldBeNil)
+			So(values(s), ShouldResemble, []string{"b"})
+
+			s, err = root.Select("|title")
+			So(err, ShouldBeNil)
+			So(values(s), ShouldResemble, []string{"A", "B", "C"})
+		})
+
+		Convey("The same namespaced Tags should be selected through the Document and the root", func() {
+			d, err := NewDocumentFromReader(strings.NewReader(`<n:feed xmlns:n="urn:media" xmlns:m="urn:media"><m:title/><n:title/></n:feed>`))
+			So(err, ShouldBeNil)
+
+			s, err := d.Select("m|feed")
+			So(err, ShouldBeNil)
+			So(s.One(), ShouldPointTo, d.Root())
+
+			fromDocument, err := d.Select("m|feed > m|title")
+			So(err, ShouldBeNil)
+			fromRoot, err := d.Root().Select("m|feed > m|title")
+			So(err, ShouldBeNil)
+			So(len(fromDocument), ShouldEqual, 2)
+			So(fromDocument, ShouldResemble, fromRoot)
+		})
+
+		Convey("Select should return a Search that composes with ByName and One", func() {
+			s, err := root.Select("item.new")
+			So(err, ShouldBeNil)
+			So(s.ByName("title").One().Prefix, ShouldEqual, "")
+
+			one, err := root.SelectOne(":root > item")
+			So(err, ShouldBeNil)
+			So(one.Attr("id").Value, ShouldEqual, "a")
+
+			one, err = root.SelectOne("missing")
+			So(err, ShouldBeNil)
+			So(one, ShouldBeNil)
+		})
+
+		Convey("The scope Tag should not be returned", func() {
+			s, err := root.Select("root")
+			So(err, ShouldBeNil)
+			So(s, ShouldBeEmpty)
+
+			s, err = d.Select("root")
+			So(err, ShouldBeNil)
+			So(s.One(), ShouldPointTo, root)
+		})
+
+		Convey("Name matching extensions should tolerate differences in case and spelling", func() {
+			s, err := root.Select("*:name-fold(ITEM) > :name-regexp('^t.*e$')")
+			So(err, ShouldBeNil)
+			So(values(s), ShouldResemble, []string{"A", "B", "b", "C"})
+
+			s, err = root.Select(":name-glob(n*)")
+			So(err, ShouldBeNil)
+			So(values(s), ShouldResemble, []string{""})
+
+			s, err = root.Select(":name-fold(ITEM)")
+			So(err, ShouldBeNil)
+			So(values(s), ShouldResemble, []string{"a", "b", "c"})
+		})
+
+		Convey("A compiled Selector should match Tags", func() {
+			sel := MustCompileSelector("item[type=audio]")
+			So(sel.String(), ShouldEqual, "item[type=audio]")
+			So(sel.Match(root), ShouldBeFalse)
+			So(values(sel.Select(root)), ShouldResemble, []string{"c"})
+		})
+	})
+
+	Convey("Given invalid selectors", t, func() {
+		for _, selector := range []string{"", "a >", "a,", "[a", "[a=]", "[a=b", "a:hover", "a:nth-child(x)", "a:not(b", "a!", ":name-regexp('(')", ":name-glob([)", ":name-fold()"} {
+			_, err := CompileSelector(selector)
+			So(err, ShouldNotBeNil)
+
+			_, ok := err.(*SelectorError)
+			So(ok, ShouldBeTrue)
+		}
+
+		Convey("The error should report the offset", func() {
+			_, err := CompileSelector("item[type=video")
+			So(err.Error(), ShouldEqual, "selector 'item[type=video' at offset 15: expected ']'")
+		})
+	})
+}
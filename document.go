@@ -3,17 +3,18 @@
 package simplexml
 
 import (
-	"encoding/xml"
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
-	"html"
 	"io"
 	"reflect"
 	"strings"
 )
 
 type Document struct {
-	Declaration string
+	// Declaration is the XML declaration written at the start of the document, nil for none
+	Declaration *Declaration
 
 	// elements is a slice of interface, gaurenteed to be a pointer through AddBefore and AddAfter
 	elements []Element
@@ -49,7 +50,7 @@ func (d Document) Root() *Tag {
 // AddBefore takes an Element pointer (add) and an optional Element pointer (before).
 // If before == nil, the add element will be prepended to the elements slice, otherwise it will be placed
 // before the 'before' element. If 'before' != nil and is not found in the current Tags elements, an error
-// will be returned. This method is not recursive.
+// will be returned. This method is not recursive. A Tag that is already part of a tree is moved rather than shared.
 func (d *Document) AddBefore(add Element, before Element) error {
 	// verify add is pointer
 	if reflect.ValueOf(add).Kind() != reflect.Ptr {
@@ -58,26 +59,24 @@ func (d *Document) AddBefore(add Element, before Element) error {
 
 	if before != nil {
 		// verify before is pointer
-		refBefore := reflect.ValueOf(before)
-		if refBefore.Kind() != reflect.Ptr {
+		if reflect.ValueOf(before).Kind() != reflect.Ptr {
 			return errors.New("non-pointer 'before' passed to AddBefore")
 		}
 
 		// find address in current tag
-		loc := -1
-		for k, v := range d.elements {
-			if refBefore.Pointer() == reflect.ValueOf(v).Pointer() {
-				loc = k
-				break
-			}
-		}
-
-		if loc >= 0 {
-			// add the new element before the matched element in the slice
-			d.elements = append(d.elements[:loc], append([]Element{add}, d.elements[loc:]...)...)
-		} else {
+		if elementIndex(d.elements, before) < 0 {
 			return errors.New("memory address of 'before' not in current Tag")
+		} else if samePointer(add, before) {
+			return nil
 		}
+	}
+
+	adopt(add, nil, d)
+
+	if before != nil {
+		// add the new element before the matched element in the slice
+		loc := elementIndex(d.elements, before)
+		d.elements = append(d.elements[:loc], append([]Element{add}, d.elements[loc:]...)...)
 	} else {
 		// prepend to elements
 		d.elements = append([]Element{add}, d.elements...)
@@ -89,7 +88,7 @@ func (d *Document) AddBefore(add Element, before Element) error {
 // AddAfter takes an Element pointer (add) and an optional Element pointer (after).
 // If before == nil, the add element will be appended to the elements slice, otherwise it will be placed
 // after the 'after' element. If 'after' != nil and is not found in the current Tags elements, an error
-// will be returned. This method is not recursive.
+// will be returned. This method is not recursive. A Tag that is already part of a tree is moved rather than shared.
 func (d *Document) AddAfter(add Element, after Element) error {
 	// verify add is pointer
 	if reflect.ValueOf(add).Kind() != reflect.Ptr {
@@ -98,26 +97,24 @@ func (d *Document) AddAfter(add Element, after Element) error {
 
 	if after != nil {
 		// verify after is pointer
-		refAfter := reflect.ValueOf(after)
-		if refAfter.Kind() != reflect.Ptr {
+		if reflect.ValueOf(after).Kind() != reflect.Ptr {
 			return errors.New("non-pointer 'after' passed to AddBefore")
 		}
 
 		// find address in current tag
-		loc := -1
-		for k, v := range d.elements {
-			if refAfter.Pointer() == reflect.ValueOf(v).Pointer() {
-				loc = k
-				break
-			}
-		}
-
-		if loc >= 0 {
-			// add the new element after the matched element in the slice
-			d.elements = append(d.elements[:loc+1], append([]Element{add}, d.elements[loc+1:]...)...)
-		} else {
+		if elementIndex(d.elements, after) < 0 {
 			return errors.New("memory address of 'after' not in current Tag")
+		} else if samePointer(add, after) {
+			return nil
 		}
+	}
+
+	adopt(add, nil, d)
+
+	if after != nil {
+		// add the new element after the matched element in the slice
+		loc := elementIndex(d.elements, after)
+		d.elements = append(d.elements[:loc+1], append([]Element{add}, d.elements[loc+1:]...)...)
 	} else {
 		// append to elements
 		d.elements = append(d.elements, add)
@@ -130,23 +127,17 @@ func (d *Document) AddAfter(add Element, after Element) error {
 // memory address is not an element of the Tag. This function is not recursive.
 func (d *Document) Remove(remove Element) error {
 	// verify remove is pointer
-	refRemove := reflect.ValueOf(remove)
-	if refRemove.Kind() != reflect.Ptr {
+	if reflect.ValueOf(remove).Kind() != reflect.Ptr {
 		return errors.New("non-pointer 'remove' passed to Remove")
 	}
 
 	// find address in current tag
-	loc := -1
-	for k, v := range d.elements {
-		if refRemove.Pointer() == reflect.ValueOf(v).Pointer() {
-			loc = k
-			break
-		}
-	}
-
-	if loc >= 0 {
+	if loc := elementIndex(d.elements, remove); loc >= 0 {
 		// delete the matched element from the slice
 		d.elements = append(d.elements[:loc], d.elements[loc+1:]...)
+		if tag, ok := remove.(*Tag); ok {
+			tag.parent, tag.document = nil, nil
+		}
 	} else {
 		return errors.New("memory address of 'remove' not in current Tag")
 	}
@@ -154,147 +145,534 @@ func (d *Document) Remove(remove Element) error {
 	return nil
 }
 
-// setIndent sets teh indent for the current document and calls setIndent on its root element
-func (d *Document) setIndent(indent string, prefix string) {
+// FirstChild returns the first child Element of the Document, or nil if it is empty.
+func (d Document) FirstChild() Element {
+	return elementAt(d.elements, 0)
+}
+
+// LastChild returns the last child Element of the Document, or nil if it is empty.
+func (d Document) LastChild() Element {
+	return elementAt(d.elements, len(d.elements)-1)
+}
+
+// ChildAt returns the child Element at index i, or nil if i is out of range.
+func (d Document) ChildAt(i int) Element {
+	return elementAt(d.elements, i)
+}
+
+// IndexOf returns the index of the child Element with the same memory address as e, or -1 if it is not a child.
+func (d Document) IndexOf(e Element) int {
+	return elementIndex(d.elements, e)
+}
+
+// NextSibling returns the child Element following e, or nil if e is the last child or not a child.
+func (d Document) NextSibling(e Element) Element {
+	return siblingElement(d.elements, e, 1)
+}
+
+// PreviousSibling returns the child Element preceding e, or nil if e is the first child or not a child.
+func (d Document) PreviousSibling(e Element) Element {
+	return siblingElement(d.elements, e, -1)
+}
+
+// FirstChildTag returns the first child Tag, or nil if there are none.
+func (d Document) FirstChildTag() *Tag {
+	return tagAt(d.elements, 0)
+}
+
+// LastChildTag returns the last child Tag, or nil if there are none.
+func (d Document) LastChildTag() *Tag {
+	for i := len(d.elements) - 1; i >= 0; i-- {
+		if t, ok := d.elements[i].(*Tag); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// ChildTagAt returns the top level Tag at index i among the top level Tags, or nil if i is out of range.
+func (d Document) ChildTagAt(i int) *Tag {
+	return tagAt(d.elements, i)
+}
+
+// IndexOfTag returns the index of tag among the top level Tags, or -1 if it is not a child.
+func (d Document) IndexOfTag(tag *Tag) int {
+	return tagIndex(d.elements, tag)
+}
+
+// NextSiblingTag returns the first child Tag following e, or nil if there is none or e is not a child.
+func (d Document) NextSiblingTag(e Element) *Tag {
+	return siblingTag(d.elements, e, 1)
+}
+
+// PreviousSiblingTag returns the last child Tag preceding e, or nil if there is none or e is not a child.
+func (d Document) PreviousSiblingTag(e Element) *Tag {
+	return siblingTag(d.elements, e, -1)
+}
+
+// Clone returns a deep copy of the Document, its Declaration and all of its Elements.
+func (d Document) Clone() *Document {
+	c := &Document{}
+
+	if d.Declaration != nil {
+		decl := *d.Declaration
+		c.Declaration = &decl
+	}
+
+	for _, v := range d.elements {
+		e := cloneElement(v)
+		if tag, ok := e.(*Tag); ok {
+			tag.document = c
+		}
+		c.elements = append(c.elements, e)
+	}
+
+	return c
+}
+
+// Doctype returns the document type declaration of the Document, or nil if it does not have one.
+func (d Document) Doctype() *Doctype {
+	for _, v := range d.elements {
+		if dt, ok := v.(*Doctype); ok {
+			return dt
+		}
+	}
+	return nil
+}
+
+// SetDoctype replaces the document type declaration of the Document. A Document without one has dt placed before its
+// first Tag, or at the end if there are no Tags. A nil dt removes the document type declaration.
+func (d *Document) SetDoctype(dt *Doctype) {
+	for k, v := range d.elements {
+		if _, ok := v.(*Doctype); ok {
+			if dt == nil {
+				d.elements = append(d.elements[:k], d.elements[k+1:]...)
+			} else {
+				d.elements[k] = dt
+			}
+			return
+		}
+	}
+
+	if dt == nil {
+		return
+	}
+
+	for _, v := range d.elements {
+		if t, ok := v.(*Tag); ok {
+			d.AddBefore(dt, t)
+			return
+		}
+	}
+	d.elements = append(d.elements, dt)
+}
+
+// setIndent sets the prefix and indent for the current document and calls setIndent on its top level Tags
+func (d *Document) setIndent(prefix string, indent string) {
 	d.formatPrefix = prefix
 	d.formatIndent = indent
-	d.Root().setIndent(prefix, indent)
+	for _, v := range d.elements {
+		if t, ok := v.(*Tag); ok {
+			t.setIndent(prefix, indent, 0)
+		}
+	}
+}
+
+// MarshalOptions configures MarshalWithOptions.
+type MarshalOptions struct {
+	// Prefix and Indent are used as in MarshalIndent, no formatting is done when both are empty
+	Prefix string
+	Indent string
+
+	// Strict refuses to marshal a malformed document, returning the first result of Errors()
+	Strict bool
+
+	// OmitDeclaration leaves the Declaration out of the output
+	OmitDeclaration bool
+
+	// Encoding is the character encoding of the output, written to the Declaration in place of its own encoding.
+	// When empty the encoding of the Declaration is used, or UTF-8 without one. Supported encodings are UTF-8,
+	// UTF-16 (written with a byte order mark), ISO-8859-1, Windows-1252 and US-ASCII. Characters the encoding can
+	// not represent are written as character references in text and attribute values. An error is returned for such
+	// a character anywhere else (names, CDATA, Comments and processing instructions), where a reference would not be
+	// read back as the character.
+	Encoding string
 }
 
-// Marshal is a wrapper for String() but returns a []byte, error to conform to the normal Marshaler interface.
-// An error will be returned if the doucment is malformed (returning the first result of Errors()).
+// Marshal is a wrapper for WriteTo but returns a []byte, error to conform to the normal Marshaler interface.
+// Only content that can not be written is refused, see MarshalWithOptions to refuse malformed documents.
 func (d Document) Marshal() ([]byte, error) {
-	d.setIndent("", "")
+	return d.MarshalWithOptions(MarshalOptions{})
+}
 
-	/*
-		TODO: Removed from scope of v0.1
+// MarshalIndent works like Marshal, but the Declaration (if any) and each top level element begin on a new line
+// starting with prefix. Tags are then indented by one or more copies of indent according to their nesting depth.
+func (d Document) MarshalIndent(prefix, indent string) ([]byte, error) {
+	return d.MarshalWithOptions(MarshalOptions{Prefix: prefix, Indent: indent})
+}
 
-		// return first error of root
-		if errs := d.Root().Errors(); len(errs) != 0 {
+// MarshalWithOptions works like Marshal and MarshalIndent, configured by the given MarshalOptions. An error will be
+// returned if Strict is set and the document is malformed (returning the first result of Errors()).
+func (d Document) MarshalWithOptions(opts MarshalOptions) ([]byte, error) {
+	if opts.Strict {
+		if errs := d.Errors(); len(errs) != 0 {
 			return nil, errs[0]
 		}
+	}
 
-	*/
+	d.setIndent(opts.Prefix, opts.Indent)
+	defer d.setIndent("", "")
+
+	var b bytes.Buffer
+	if _, err := d.write(&b, opts); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Errors checks the Declaration and that the document contains exactly one root Tag and no text outside of it, then
+// returns the result of Errors() on each top level Tag. Comments, processing instructions and the DOCTYPE outside of
+// the root are checked as well.
+func (d Document) Errors() []error {
+	var errs []error
+	var roots, doctypes int
+
+	if d.Declaration != nil {
+		errs = append(errs, d.Declaration.Errors()...)
+	}
 
-	// build the document
-	s := ""
 	for _, v := range d.elements {
-		s = s + v.String()
+		switch k := v.(type) {
+		case *Tag:
+			roots++
+			errs = append(errs, k.errors(nil)...)
+		case *Doctype:
+			doctypes++
+			if !isXMLName(strings.Replace(k.Name, ":", "_", -1)) {
+				errs = append(errs, &ValidationError{Path: XPath{}, Msg: fmt.Sprintf("invalid DOCTYPE name '%s'", k.Name)})
+			}
+			if k.PublicID != "" && k.SystemID == "" {
+				errs = append(errs, &ValidationError{Path: XPath{}, Msg: "DOCTYPE public identifier without a system identifier"})
+			}
+			if roots > 0 {
+				errs = append(errs, &ValidationError{Path: XPath{}, Msg: "DOCTYPE after the root element"})
+			}
+		case *Value:
+			if strings.TrimSpace(string(*k)) != "" {
+				errs = append(errs, &ValidationError{Path: XPath{}, Msg: "value outside of the root element"})
+			}
+		case *CDATA:
+			errs = append(errs, &ValidationError{Path: XPath{}, Msg: "CDATA outside of the root element"})
+		case *Comment:
+			if strings.Contains(string(*k), "--") || strings.HasSuffix(string(*k), "-") {
+				errs = append(errs, &ValidationError{Path: XPath{}, Msg: "contains invalid Comments"})
+			}
+		case *ProcInst:
+			if !k.valid() {
+				errs = append(errs, &ValidationError{Path: XPath{}, Msg: fmt.Sprintf("contains invalid processing instruction '%s'", k.Target)})
+			}
+		}
+	}
+
+	if roots == 0 {
+		errs = append(errs, &ValidationError{Path: XPath{}, Msg: "the document does not contain a root element"})
+	} else if roots > 1 {
+		errs = append(errs, &ValidationError{Path: XPath{}, Msg: "the document contains more than one root element"})
+	}
+
+	if doctypes > 1 {
+		errs = append(errs, &ValidationError{Path: XPath{}, Msg: "the document contains more than one DOCTYPE"})
+	}
+
+	return errs
+}
+
+// WriteTo implements the io.WriterTo interface. WriteTo writes the Declaration (if any) and the document's elements
+// to w through a buffer, without building the whole document in memory. The output is in the encoding of the
+// Declaration, or in UTF-8 with a rewritten Declaration if that encoding is not supported for output. An error is
+// returned for content that can not be written, such as a DOCTYPE public identifier without a system identifier.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	return d.write(w, MarshalOptions{})
+}
+
+// write writes the document to w through a buffer, in the encoding of opts or the Declaration
+func (d *Document) write(w io.Writer, opts MarshalOptions) (int64, error) {
+	// String can not write a public identifier without a system identifier
+	for _, v := range d.elements {
+		if dt, ok := v.(*Doctype); ok && dt.PublicID != "" && dt.SystemID == "" {
+			return 0, &ValidationError{Path: XPath{}, Msg: "DOCTYPE public identifier without a system identifier"}
+		}
+	}
+
+	decl := d.Declaration
+	encoding := opts.Encoding
+	if encoding == "" && decl != nil {
+		encoding = decl.Encoding
+	}
+
+	cs, err := lookupCharset(encoding)
+	if err != nil {
+		if opts.Encoding != "" {
+			return 0, err
+		}
+		// a document read through a CharsetReader may declare an encoding that can not be written, fall back to UTF-8
+		encoding, cs = "UTF-8", nil
 	}
 
-	return []byte(s), nil
+	// the written declaration names the output encoding
+	if encoding != "" && (decl == nil && opts.Encoding != "" || decl != nil && decl.Encoding != encoding) {
+		out := Declaration{Version: "1.0", Encoding: encoding}
+		if decl != nil {
+			out.Version, out.Standalone = decl.Version, decl.Standalone
+		}
+		decl = &out
+	}
+	if opts.OmitDeclaration {
+		decl = nil
+	}
+
+	cw := &countWriter{w: w}
+	var out io.Writer = cw
+	if cs != nil {
+		if err := cs.checkMarkup(d.elements, XPath{}, encoding); err != nil {
+			return 0, err
+		}
+		out = &charsetWriter{w: cw, cs: cs}
+	}
+
+	bw := bufio.NewWriter(out)
+	d.writeTo(bw, decl)
+	err = bw.Flush()
+	return cw.n, err
+}
+
+// writeTo writes decl (if any) and the document's elements to w. When an indent is set, the declaration and each top
+// level element are written on their own line.
+func (d *Document) writeTo(w xmlWriter, decl *Declaration) {
+	indented := d.formatPrefix != "" || d.formatIndent != ""
+
+	if !indented {
+		if decl != nil {
+			w.WriteString(decl.String())
+		}
+		for _, v := range d.elements {
+			writeElement(w, v)
+		}
+		return
+	}
+
+	first := true
+	if decl != nil {
+		w.WriteString(d.formatPrefix)
+		w.WriteString(decl.String())
+		first = false
+	}
+
+	for _, v := range d.elements {
+		// each element is already on its own line
+		if _, ok := v.(*Whitespace); ok {
+			continue
+		}
+
+		if !first {
+			w.WriteByte('\n')
+		}
+		first = false
+
+		// Tags write their own prefix
+		if _, ok := v.(*Tag); !ok {
+			w.WriteString(d.formatPrefix)
+		}
+		writeElement(w, v)
+	}
 }
 
+// NewDocument returns a new Document with the given Tag as its root element
 func NewDocument(t *Tag) *Document {
-	return &Document{elements: []Element{t}}
+	d := &Document{}
+	d.AddAfter(t, nil)
+	return d
 }
 
-// NewDocumentFromReader returns a new Document that is generated from an io.Reader using encoding/xml.Decoder
-//
-// BUG(kyle) Due to the design of xml.Decoder, Tags that define their namespace without a prefix will
-// be converted to use the prefix if it was defined in a parent for use. The result will be a valid document,
-// namespaces stay the same, just the resulting documents format is slightly different. This will be addressed once
-// simplexml is no longer reliant on encoding/xml.
+// ParseOptions configures NewDocumentFromReaderWithOptions.
+type ParseOptions struct {
+	// PreserveWhitespace keeps whitespace only character data as Whitespace elements, which Marshal writes back
+	// verbatim. By default it is dropped. Within an element and its descendants an xml:space attribute overrides
+	// this setting, 'preserve' keeps whitespace and 'default' drops it.
+	PreserveWhitespace bool
+
+	// DecodeReferences decodes every predefined entity and character reference into the surrounding Value instead
+	// of keeping references other than &amp; and &lt; as EntityRef elements.
+	DecodeReferences bool
+
+	// CharsetReader, if set, converts input in an encoding other than UTF-8, UTF-16, ISO-8859-1, Windows-1252 and
+	// US-ASCII to UTF-8, as in xml.Decoder.
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+}
+
+// NewDocumentFromReader returns a new Document that is generated from an io.Reader. A *ParseError is returned if the
+// reader does not contain a well formed document.
 //
-// eg: <foo xmlns:urn="http://foo"><bar xmlns="http://foo"/></foo> will be converted to
-// <foo xmlns:urn="http://foo"><urn:bar xmlns="http://foo"/></foo>
+// The document is read with simplexml's own tokenizer, which keeps its source form: each Tag and Attribute keeps the
+// literal prefix (or lack of one) used in the document, namespace declarations and the quote around each attribute
+// value are kept as they were written, CDATA sections are kept as CDATA and references other than &amp; and &lt;
+// are kept as EntityRef elements. Namespaces can be resolved with GetNamespace.
 func NewDocumentFromReader(r io.Reader) (*Document, error) {
-	var start xml.StartElement
+	return NewDocumentFromReaderWithOptions(r, ParseOptions{})
+}
+
+// NewDocumentFromReaderWithOptions works like NewDocumentFromReader, configured by the given ParseOptions.
+func NewDocumentFromReaderWithOptions(r io.Reader, opts ParseOptions) (*Document, error) {
 	var tree []*Tag
-	var root *Tag
 
-	doc := &Document{}
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
 
-	d := xml.NewDecoder(r)
-	for {
-		tok, _ := d.Token()
+	if src, err = decodeCharset(src, opts.CharsetReader); err != nil {
+		return nil, newParseError(newTokenizer(nil), 0, nil, err)
+	}
 
-		// done decoding on nil token
-		if tok == nil {
-			break
-		}
+	doc := &Document{}
 
-		switch t := tok.(type) {
-		case xml.StartElement:
-			var tag *Tag
-			start = t.Copy() // start is used through iterations
+	// preserve holds the whitespace setting of each Tag in the tree, the document level is the option itself
+	preserve := []bool{opts.PreserveWhitespace}
 
-			// initiate root for first start element
-			if len(tree) == 0 {
-				root = &Tag{}
+	// add appends an element to the latest Tag in the tree, otherwise to the document
+	add := func(e Element) {
+		tag, _ := e.(*Tag)
+		if len(tree) > 0 {
+			tree[len(tree)-1].elements = append(tree[len(tree)-1].elements, e)
+			if tag != nil {
+				tag.parent = tree[len(tree)-1]
 			}
+		} else {
+			doc.elements = append(doc.elements, e)
+			if tag != nil {
+				tag.document = doc
+			}
+		}
+	}
 
-			// set tag to be used, root if tree is empty, otherwise a new tag which is added to the tree
-			if len(tree) == 0 {
-				tag = root
-				doc.elements = append(doc.elements, root)
-			} else {
-				tag = &Tag{parents: tree}
-				tree[len(tree)-1].elements = append(tree[len(tree)-1].elements, tag)
+	z := newTokenizer(src)
+	for {
+		t, ok, err := z.next()
+		if err != nil {
+			offset := len(src)
+			if te, ok := err.(*tokenError); ok {
+				offset = te.offset
 			}
+			return nil, newParseError(z, offset, tree, err)
+		} else if !ok {
+			// done at the end of the input
+			break
+		}
 
-			// attributes need set so that available namespaces are updated
-			for _, attr := range start.Attr {
-				tag.AddAttribute(attr.Name.Local, attr.Value, attr.Name.Space)
+		switch t.kind {
+		case startToken:
+			tag := &Tag{
+				Name:       t.name,
+				Prefix:     t.prefix,
+				Attributes: t.attrs,
 			}
+			add(tag)
 
-			// set tags name and prefix
-			tag.Name = start.Name.Local
-			prefix, err := tag.GetPrefix(start.Name.Space)
-			if err == nil {
-				tag.Prefix = prefix
+			// self closing tags are done, otherwise add new tag to the end of the tree
+			if !t.selfClosing {
+				tree = append(tree, tag)
+				preserve = append(preserve, preserveWhitespace(tag, preserve[len(preserve)-1]))
+			}
+		case endToken:
+			if len(tree) == 0 {
+				return nil, newParseError(z, t.offset, tree, fmt.Errorf("unexpected end element </%s>", joinPrefix(t.prefix, t.name)))
+			}
+			if open := tree[len(tree)-1]; open.Name != t.name || open.Prefix != t.prefix {
+				return nil, newParseError(z, t.offset, tree, fmt.Errorf("element <%s> closed by </%s>", open.qualifiedName(), joinPrefix(t.prefix, t.name)))
 			}
 
-			// add new tag to the end of the tree
-			tree = append(tree, tag)
-		case xml.EndElement:
-			// done with the element, drop it from working tree and reset start token
+			// done with the element, drop it from working tree
 			tree = tree[:len(tree)-1]
-			start = xml.StartElement{}
-		case xml.CharData:
-			// skip whitespace
-			if strings.TrimSpace(string(t)) != "" {
-				// decode the value
-				v := html.UnescapeString(string(t))
-
-				// add to latest element if elements in tree, otherwise add to doc
-				if len(tree) > 0 {
-					if NeedCDATA(v) {
-						tree[len(tree)-1].AddAfter(NewCDATA(v), nil)
-					} else {
-						tree[len(tree)-1].AddAfter(NewValue(v), nil)
-					}
-				} else {
-					if NeedCDATA(v) {
-						doc.elements = append(doc.elements, NewCDATA(v))
-					} else {
-						doc.elements = append(doc.elements, NewValue(v))
-					}
-
+			preserve = preserve[:len(preserve)-1]
+		case textToken:
+			// keep or skip whitespace
+			if strings.TrimSpace(t.text) == "" {
+				if preserve[len(preserve)-1] {
+					add(NewWhitespace(t.text))
 				}
+				continue
 			}
-		case xml.Comment:
-			// decode the value
-			v := html.UnescapeString(string(t))
 
-			// add to latest element if elements in tree, otherwise add to doc
-			if len(tree) > 0 {
-				tree[len(tree)-1].AddAfter(NewComment(v), nil)
+			elements, err := textElements(t.text, opts.DecodeReferences)
+			if err != nil {
+				return nil, newParseError(z, t.offset, tree, err)
+			}
+			for _, e := range elements {
+				add(e)
+			}
+		case cdataToken:
+			add(NewCDATA(t.text))
+		case commentToken:
+			add(NewComment(t.text))
+		case procInstToken:
+			// the XML declaration may only appear at the start of the document, anything else is kept in place
+			if !strings.EqualFold(t.name, "xml") {
+				add(NewProcInst(t.name, t.text))
+			} else if t.name == "xml" && len(tree) == 0 && len(doc.elements) == 0 && doc.Declaration == nil {
+				if doc.Declaration, err = parseDeclaration(t.text); err != nil {
+					return nil, newParseError(z, t.offset, tree, err)
+				}
+				doc.Declaration.keepSource(string(z.src[t.offset:z.pos]))
 			} else {
-				doc.elements = append(doc.elements, NewComment(v))
+				return nil, newParseError(z, t.offset, tree, errors.New("misplaced XML declaration"))
+			}
+		case directiveToken:
+			// only a DOCTYPE before the root element is kept, other directives are eaten
+			if !strings.HasPrefix(t.text, "DOCTYPE") {
+				continue
 			}
-		case xml.ProcInst:
-			doc.Declaration = fmt.Sprintf("<?xml %s?>", string(t.Inst))
-		default:
-			// eat token
+
+			if len(tree) > 0 || doc.Doctype() != nil || doc.hasTag() {
+				return nil, newParseError(z, t.offset, tree, errors.New("misplaced DOCTYPE"))
+			}
+
+			dt, err := parseDoctype(t.text)
+			if err != nil {
+				return nil, newParseError(z, t.offset, tree, err)
+			}
+			add(dt)
 		}
 	}
 
 	// we should be back down to the root tag
 	if len(tree) != 0 {
-		// TODO: position of failure
-		return nil, errors.New("malformed document")
+		return nil, newParseError(z, len(src), tree, errors.New("malformed document"))
 	}
 
 	return doc, nil
 }
+
+// hasTag returns true if the document contains a top level Tag
+func (d Document) hasTag() bool {
+	for _, v := range d.elements {
+		if _, ok := v.(*Tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// preserveWhitespace returns whether whitespace is kept within tag, given the setting of its parent
+func preserveWhitespace(tag *Tag, parent bool) bool {
+	for _, attr := range tag.Attributes {
+		if attr.Prefix == "xml" && attr.Name == "space" {
+			switch attr.Value {
+			case "preserve":
+				return true
+			case "default":
+				return false
+			}
+		}
+	}
+	return parent
+}
@@ -4,7 +4,9 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
 
+	"bytes"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -129,7 +131,7 @@ func TestNewDocument(t *testing.T) {
 		d := &Document{elements: []Element{&Tag{Name: "foo"}}}
 
 		Convey("Its declaration should be empty", func() {
-			So(d.Declaration, ShouldBeEmpty)
+			So(d.Declaration, ShouldBeNil)
 		})
 
 		Convey("It should have one element", func() {
@@ -176,10 +178,10 @@ func TestNewDocumentFromReader(t *testing.T) {
 						So(v, ShouldBeEmpty)
 					})
 
-					Convey("The value of fizz should equal '<cdata>contents</cdata>'", func() {
+					Convey("The value of fizz should equal '&lt;cdata&gt;contents&lt;/cdata&gt;'", func() {
 						v, err := foo.elements[2].Value()
 						So(err, ShouldBeNil)
-						So(v, ShouldEqual, "<cdata>contents</cdata>")
+						So(v, ShouldEqual, "&lt;cdata&gt;contents&lt;/cdata&gt;")
 					})
 
 					Convey("The fizz element should have 1 CDATA element", func() {
@@ -190,8 +192,268 @@ func TestNewDocumentFromReader(t *testing.T) {
 			})
 		})
 
-		Convey("The declaration should be the same as the string version", func() {
-			So(d.Declaration, ShouldEqual, "<?xml version=\"1.0\" encoding=\"UTF-8\" standalone=\"no\" ?>")
+		Convey("The declaration should be parsed into its pseudo attributes", func() {
+			So([]string{d.Declaration.Version, d.Declaration.Encoding, d.Declaration.Standalone}, ShouldResemble, []string{"1.0", "UTF-8", "no"})
+		})
+	})
+}
+
+func TestParseError(t *testing.T) {
+	Convey("Given NewDocumentFromReader from a document with a mismatched end tag", t, func() {
+		_, err := NewDocumentFromReader(strings.NewReader("<root>\n\t<foo>\n\t\t<bar></baz>\n\t</foo>\n</root>"))
+
+		Convey("The error should be a *ParseError describing the failure", func() {
+			So(err, ShouldNotBeNil)
+			pe, ok := err.(*ParseError)
+			So(ok, ShouldBeTrue)
+			So(pe.Line, ShouldEqual, 3)
+			So(pe.Column, ShouldBeGreaterThan, 1)
+			So(pe.Offset, ShouldBeGreaterThan, 0)
+			So(pe.Path.String(), ShouldEqual, "/root/foo/bar")
+			So(pe.Unwrap().Error(), ShouldEqual, "element <bar> closed by </baz>")
+			So(pe.Error(), ShouldContainSubstring, "/root/foo/bar")
+		})
+	})
+
+	Convey("Given NewDocumentFromReader from a document with an unclosed tag", t, func() {
+		_, err := NewDocumentFromReader(strings.NewReader("<root><foo>"))
+
+		Convey("The error should be a *ParseError with the open path", func() {
+			So(err, ShouldNotBeNil)
+			pe, ok := err.(*ParseError)
+			So(ok, ShouldBeTrue)
+			So(pe.Path.String(), ShouldEqual, "/root/foo")
+		})
+	})
+}
+
+func TestNamespaceRoundTrip(t *testing.T) {
+	Convey("Given a document mixing prefixed and default namespaces for the same URI", t, func() {
+		x := `<foo xmlns:urn="http://foo" xmlns:a="http://a"><bar xmlns="http://foo" a:b="c"><urn:baz/><bat/></bar></foo>`
+		d, err := NewDocumentFromReader(strings.NewReader(x))
+		So(err, ShouldBeNil)
+
+		Convey("Marshal should keep each prefix and declaration as written", func() {
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, x)
+		})
+
+		Convey("Namespaces should resolve through the nearest declaration", func() {
+			bat := d.Root().Search().ByName("bar").ByName("bat").One()
+			So(bat.Prefix, ShouldBeEmpty)
+			ns, err := bat.GetNamespace("")
+			So(err, ShouldBeNil)
+			So(ns, ShouldEqual, "http://foo")
+
+			bar := d.Root().Search().ByName("bar").One()
+			So(bar.Attributes[1].Prefix, ShouldEqual, "a")
+			So(bar.Errors(), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestPreserveWhitespace(t *testing.T) {
+	Convey("Given an indented document with an xml:space attribute", t, func() {
+		x := "<foo>\n\t<bar xml:space=\"preserve\"> <baz/>\t</bar>\n\t<bat> </bat>\n</foo>"
+
+		Convey("By default only the xml:space element should keep its whitespace", func() {
+			d, err := NewDocumentFromReader(strings.NewReader(x))
+			So(err, ShouldBeNil)
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "<foo><bar xml:space=\"preserve\"> <baz/>\t</bar><bat/></foo>")
+		})
+
+		Convey("With PreserveWhitespace Marshal should return the document verbatim", func() {
+			d, err := NewDocumentFromReaderWithOptions(strings.NewReader(x), ParseOptions{PreserveWhitespace: true})
+			So(err, ShouldBeNil)
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, x)
+
+			bat := d.Root().Search().ByName("bat").One()
+			v, err := bat.Value()
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, " ")
+
+			v, err = d.Root().Value()
+			So(err, ShouldBeNil)
+			So(v, ShouldBeEmpty)
+		})
+
+		Convey("xml:space='default' should drop whitespace within a preserved document", func() {
+			d, err := NewDocumentFromReaderWithOptions(strings.NewReader(`<foo> <bar xml:space="default"> <baz/> </bar> </foo>`), ParseOptions{PreserveWhitespace: true})
+			So(err, ShouldBeNil)
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `<foo> <bar xml:space="default"><baz/></bar> </foo>`)
+		})
+	})
+}
+
+func TestProcInst(t *testing.T) {
+	Convey("Given a document with processing instructions after the declaration and within the root", t, func() {
+		x := `<?xml version="1.0"?><?xml-stylesheet type="text/xsl" href="rss.xsl"?><rss><?php echo 1; ?><channel/></rss>`
+		d, err := NewDocumentFromReader(strings.NewReader(x))
+		So(err, ShouldBeNil)
+
+		Convey("The declaration should only come from the XML declaration", func() {
+			So([]string{d.Declaration.Version, d.Declaration.Encoding, d.Declaration.Standalone}, ShouldResemble, []string{"1.0", "", ""})
+		})
+
+		Convey("Each instruction should be kept at its position as a ProcInst", func() {
+			So(d.elements[0], ShouldResemble, NewProcInst("xml-stylesheet", `type="text/xsl" href="rss.xsl"`))
+			So(d.Root().elements[0], ShouldResemble, NewProcInst("php", "echo 1; "))
+
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, x)
+			So(d.Errors(), ShouldBeEmpty)
+		})
+
+		Convey("processing-instruction() should select instructions by target", func() {
+			v, err := MustCompile("string(processing-instruction('php'))").Evaluate(d.Root())
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, "echo 1; ")
+
+			v, err = MustCompile("count(processing-instruction('xml-stylesheet'))").Evaluate(d.Root())
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 0)
+
+			v, err = MustCompile("string(/processing-instruction('xml-stylesheet'))").Evaluate(d.Root())
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, `type="text/xsl" href="rss.xsl"`)
+		})
+	})
+
+	Convey("Given a document with an XML declaration after the root element", t, func() {
+		_, err := NewDocumentFromReader(strings.NewReader(`<root/><?xml version="1.0"?>`))
+
+		Convey("NewDocumentFromReader should return an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a ProcInst with a reserved target", t, func() {
+		d := NewDocument(&Tag{Name: "root"})
+		d.AddBefore(NewProcInst("XML", "version='1.0'"), nil)
+
+		Convey("Errors should report it", func() {
+			So(len(d.Errors()), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestDeclaration(t *testing.T) {
+	Convey("Given a new Document with a Declaration from NewDeclaration", t, func() {
+		d := NewDocument(&Tag{Name: "root"})
+		d.Declaration = NewDeclaration()
+
+		Convey("Marshal should write the declaration before the root element", func() {
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `<?xml version="1.0" encoding="UTF-8"?><root/>`)
+		})
+
+		Convey("OmitDeclaration should leave it out", func() {
+			b, err := d.MarshalWithOptions(MarshalOptions{OmitDeclaration: true})
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "<root/>")
+		})
+
+		Convey("An invalid standalone value should be reported by Errors", func() {
+			d.Declaration.Standalone = "maybe"
+			So(len(d.Errors()), ShouldEqual, 1)
+		})
+
+		Convey("Changing it should not change later Declarations", func() {
+			d.Declaration.Encoding = "ISO-8859-1"
+			So(NewDeclaration().Encoding, ShouldEqual, "UTF-8")
+			So(NewDeclaration(), ShouldNotPointTo, NewDeclaration())
+		})
+	})
+
+	Convey("Given declarations with missing, misordered or invalid pseudo attributes", t, func() {
+		for _, x := range []string{`<?xml encoding="UTF-8"?><a/>`, `<?xml encoding="UTF-8" version="1.0"?><a/>`, `<?xml version="2.0"?><a/>`} {
+			_, err := NewDocumentFromReader(strings.NewReader(x))
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestDoctype(t *testing.T) {
+	Convey("Given a document with a DOCTYPE containing an internal subset", t, func() {
+		x := `<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd" [<!ENTITY v "1.0">]><plist/>`
+		d, err := NewDocumentFromReader(strings.NewReader(x))
+		So(err, ShouldBeNil)
+
+		Convey("Doctype should return each part of the declaration", func() {
+			dt := d.Doctype()
+			So(dt, ShouldNotBeNil)
+			So(dt.Name, ShouldEqual, "plist")
+			So(dt.PublicID, ShouldEqual, "-//Apple//DTD PLIST 1.0//EN")
+			So(dt.SystemID, ShouldEqual, "http://www.apple.com/DTDs/PropertyList-1.0.dtd")
+			So(dt.InternalSubset, ShouldEqual, `<!ENTITY v "1.0">`)
+		})
+
+		Convey("Marshal should write the DOCTYPE before the root element", func() {
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd" [<!ENTITY v "1.0">]><plist/>`)
+			So(d.Errors(), ShouldBeEmpty)
+		})
+
+		Convey("SetDoctype(nil) should remove it", func() {
+			d.SetDoctype(nil)
+			So(d.Doctype(), ShouldBeNil)
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "<plist/>")
+		})
+	})
+
+	Convey("Given a new XHTML document with a comment before the root", t, func() {
+		d := NewDocument(&Tag{Name: "html"})
+		d.AddBefore(NewComment(" page "), nil)
+		d.SetDoctype(NewDoctype("html", "-//W3C//DTD XHTML 1.0 Strict//EN", "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd"))
+
+		Convey("MarshalIndent should place the DOCTYPE directly before the root element", func() {
+			b, err := d.MarshalIndent("", "  ")
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "<!-- page -->\n<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Strict//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd\">\n<html/>")
+		})
+	})
+
+	Convey("Given a DOCTYPE with a public identifier and no system identifier", t, func() {
+		d := NewDocument(NewTag("html"))
+		d.SetDoctype(NewDoctype("html", "-//W3C//DTD XHTML 1.0 Strict//EN", ""))
+
+		Convey("Errors should report it and Strict marshaling should fail", func() {
+			errs := d.Errors()
+			So(len(errs), ShouldEqual, 1)
+			So(errs[0].Error(), ShouldEqual, "DOCTYPE public identifier without a system identifier")
+
+			_, err := d.MarshalWithOptions(MarshalOptions{Strict: true})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Marshal should return an error instead of dropping the public identifier", func() {
+			_, err := d.Marshal()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "DOCTYPE public identifier without a system identifier")
+
+			var w bytes.Buffer
+			_, err = d.WriteTo(&w)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a DOCTYPE after the root element", t, func() {
+		_, err := NewDocumentFromReader(strings.NewReader(`<root/><!DOCTYPE root>`))
+
+		Convey("NewDocumentFromReader should return an error", func() {
+			So(err, ShouldNotBeNil)
 		})
 	})
 }
@@ -211,11 +473,150 @@ func TestMarshal(t *testing.T) {
 		})
 
 		Convey("String representation should equal the original document less whitespace", func() {
-			So(string(b), ShouldEqual, "<!-- comment above root element --><root><!-- <comment>above foo</comment> --><foo><bar>bat</bar><baz/><fizz><![CDATA[&lt;cdata&gt;contents&lt;/cdata&gt;]]></fizz></foo></root><!-- comment below root element -->")
+			So(string(b), ShouldEqual, "<?xml version=\"1.0\" encoding=\"UTF-8\" standalone=\"no\" ?><!-- comment above root element --><root><!-- <comment>above foo</comment> --><foo><bar>bat</bar><baz/><fizz><![CDATA[&lt;cdata&gt;contents&lt;/cdata&gt;]]></fizz></foo></root><!-- comment below root element -->")
+		})
+	})
+}
+
+func TestMarshalIndent(t *testing.T) {
+	Convey("Given the result of MarshalIndent(\"\", \"  \") from ExampleValidXML1 with a deeper tree", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(ExampleValidXML1))
+		So(err, ShouldBeNil)
+
+		baz := d.Root().Search().ByName("foo").ByName("baz").One()
+		So(baz, ShouldNotBeNil)
+		deep := NewTag("deep")
+		deep.AddAfter(NewValue("text "), nil)
+		deep.AddAfter(NewTag("inline"), nil)
+		baz.AddAfter(NewComment(" baz comment "), nil)
+		baz.AddAfter(deep, nil)
+
+		b, err := d.MarshalIndent("", "  ")
+
+		Convey("Error should be nil", func() {
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Each element should be on its own line and indented by its depth, mixed content should be inline", func() {
+			So(string(b), ShouldEqual, `<?xml version="1.0" encoding="UTF-8" standalone="no" ?>
+<!-- comment above root element -->
+<root>
+  <!-- <comment>above foo</comment> -->
+  <foo>
+    <bar>bat</bar>
+    <baz>
+      <!-- baz comment -->
+      <deep>text <inline/></deep>
+    </baz>
+    <fizz><![CDATA[&lt;cdata&gt;contents&lt;/cdata&gt;]]></fizz>
+  </foo>
+</root>
+<!-- comment below root element -->`)
+		})
+
+		Convey("A following Marshal should not be indented", func() {
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldNotContainSubstring, "\n")
+		})
+
+		Convey("MarshalIndent of a Tag should start at a depth of 0 with the given prefix", func() {
+			b, err := baz.MarshalIndent("\t", " ")
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "\t<baz>\n\t <!-- baz comment -->\n\t <deep>text <inline/></deep>\n\t</baz>")
+		})
+	})
+}
+
+func TestDocumentErrors(t *testing.T) {
+	Convey("Given a Document with two root elements and text outside of them", t, func() {
+		d := NewDocument(NewTag("foo"))
+		d.AddAfter(NewValue("text"), nil)
+		d.AddAfter(NewTag("bar"), nil)
+
+		Convey("Errors() should report the text and the extra root", func() {
+			errs := d.Errors()
+			So(len(errs), ShouldEqual, 2)
+			So(errs[0].Error(), ShouldEqual, "value outside of the root element")
+			So(errs[1].Error(), ShouldEqual, "the document contains more than one root element")
+		})
+
+		Convey("MarshalWithOptions should refuse to marshal when Strict", func() {
+			b, err := d.MarshalWithOptions(MarshalOptions{Strict: true})
+			So(err, ShouldNotBeNil)
+			So(b, ShouldBeNil)
+
+			b, err = d.MarshalWithOptions(MarshalOptions{})
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "<foo/>text<bar/>")
+		})
+	})
+
+	Convey("Given a Document with mixed content containing escaped characters", t, func() {
+		x := `<a>x &lt; y &amp; z &gt; 1<b/>]]&gt;</a>`
+		d, err := NewDocumentFromReader(strings.NewReader(x))
+		So(err, ShouldBeNil)
+
+		Convey("MarshalWithOptions should marshal it when Strict", func() {
+			So(d.Errors(), ShouldBeEmpty)
+			b, err := d.MarshalWithOptions(MarshalOptions{Strict: true, OmitDeclaration: true})
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, x)
+		})
+	})
+
+	Convey("Given the Document from ExampleValidXML1", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(ExampleValidXML1))
+		So(err, ShouldBeNil)
+
+		Convey("Errors() should be empty", func() {
+			So(d.Errors(), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestWriteTo(t *testing.T) {
+	Convey("Given a Document from ExampleValidXML1 written with WriteTo", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(ExampleValidXML1))
+		So(err, ShouldBeNil)
+
+		var b bytes.Buffer
+		n, err := d.WriteTo(&b)
+		So(err, ShouldBeNil)
+
+		Convey("The output should equal Marshal and the byte count should match", func() {
+			m, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(b.String(), ShouldEqual, string(m))
+			So(n, ShouldEqual, len(m))
+		})
+
+		Convey("WriteTo of the root Tag should equal its String()", func() {
+			var tb bytes.Buffer
+			n, err := d.Root().WriteTo(&tb)
+			So(err, ShouldBeNil)
+			So(tb.String(), ShouldEqual, d.Root().String())
+			So(n, ShouldEqual, tb.Len())
 		})
 	})
 }
 
+func BenchmarkWriteTo(b *testing.B) {
+	root := NewTag("root")
+	for i := 0; i < 1000; i++ {
+		item := NewTag("item")
+		item.AddAttribute("id", fmt.Sprint(i), "")
+		item.AddAfter(NewValue("value & more"), nil)
+		root.AddAfter(item, nil)
+	}
+	d := NewDocument(root)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.WriteTo(io.Discard)
+	}
+}
+
 func ExampleNewDocument() {
 	root := NewTag("root")   // a tag is an element that can contain other elements
 	doc := NewDocument(root) // a document can only contain one root tag
@@ -269,5 +670,5 @@ func ExampleNewDocumentFromReader() {
 
 	fmt.Println("fizz: ", fv)
 	//Output:
-	//fizz:  <foo>contents</foo>
+	//fizz:  &lt;foo&gt;contents&lt;/foo&gt;
 }
@@ -0,0 +1,67 @@
+package simplexml
+
+import (
+	"fmt"
+)
+
+// ParseError is returned by NewDocumentFromReader when the reader does not contain a well formed document. It
+// describes where in the input the failure occurred and which elements were open at the time.
+type ParseError struct {
+	// Line and Column are the 1 based position in the input at which the failure occurred
+	Line   int
+	Column int
+
+	// Offset is the byte offset in the input at which the failure occurred
+	Offset int64
+
+	// Path is the XPath of the elements that were open at the point of failure
+	Path XPath
+
+	// Err is the underlying error
+	Err error
+}
+
+// Error implements the error interface
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d (offset %d) at '%s': %s", e.Line, e.Column, e.Offset, e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// newParseError returns a *ParseError for the given offset of the tokenizer and the given tree of open Tags
+func newParseError(z *tokenizer, offset int, tree []*Tag, err error) *ParseError {
+	line, column := z.position(offset)
+
+	path := XPath{}
+	for _, t := range tree {
+		path = append(path, t.qualifiedName())
+	}
+
+	return &ParseError{
+		Line:   line,
+		Column: column,
+		Offset: int64(offset),
+		Path:   path,
+		Err:    err,
+	}
+}
+
+// ValidationError is returned by Errors for each problem that makes a Tag or Document malformed
+type ValidationError struct {
+	// Path is the XPath of the offending Tag, or empty for problems at the Document level
+	Path XPath
+
+	// Msg describes the problem
+	Msg string
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	if len(e.Path) == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
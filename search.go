@@ -1,15 +1,115 @@
 package simplexml
 
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
 // Search is a slice of *Tag
 type Search []*Tag
 
-// ByName searches through the children Tags of each element in Search looking for case sensitive matches of Name and returns a new Search of the results. Namespace is ignored.
+// ByName searches through the children Tags of each element in Search looking for case sensitive matches of Name and returns a new Search of the results. Namespace is ignored, see ByNamespace to match on it.
 func (se Search) ByName(s string) Search {
+	return se.children(func(t *Tag) bool {
+		return t.Name == s
+	})
+}
+
+// ByNameFold works like ByName, but matches Name without regard to case (eg. 'impressionurl' matches
+// 'ImpressionURL' and 'impressionUrl').
+func (se Search) ByNameFold(s string) Search {
+	return se.children(func(t *Tag) bool {
+		return strings.EqualFold(t.Name, s)
+	})
+}
+
+// ByNameRegexp works like ByName, but returns the children whose Name matches re. Use an anchored expression to
+// match the whole Name (eg. '(?i)^impression_?url$').
+func (se Search) ByNameRegexp(re *regexp.Regexp) Search {
+	return se.children(func(t *Tag) bool {
+		return re.MatchString(t.Name)
+	})
+}
+
+// ByNameGlob works like ByName, but returns the children whose Name matches a shell pattern in the syntax of
+// path.Match (eg. 'impression*'). A malformed pattern matches nothing.
+func (se Search) ByNameGlob(pattern string) Search {
+	return se.children(func(t *Tag) bool {
+		return matchGlob(pattern, t.Name)
+	})
+}
+
+// matchGlob returns true if name matches the shell pattern, and false for a malformed pattern
+func matchGlob(pattern, name string) bool {
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
+
+// One returns the top result off of a Search
+func (se Search) One() *Tag {
+	if len(se) > 0 {
+		return se[0]
+	}
+	return nil
+}
+
+// Descendants returns a new Search of all Tags below each element in Search, in document order. Tags below more
+// than one element of Search are only returned once.
+func (se Search) Descendants() Search {
+	var r Search
+	seen := map[*Tag]bool{}
+
+	var walk func(t *Tag)
+	walk = func(t *Tag) {
+		for _, v := range t.Tags() {
+			if !seen[v] {
+				seen[v] = true
+				r = append(r, v)
+				walk(v)
+			}
+		}
+	}
+
+	for _, v := range se {
+		walk(v)
+	}
+
+	// Tags of an element that is listed after one of its descendants were walked out of order
+	if len(se) > 1 {
+		return r.Sort()
+	}
+	return r
+}
+
+// DescendantsByName works like ByName, but searches all Tags below each element in Search rather than its children.
+func (se Search) DescendantsByName(s string) Search {
+	return se.Descendants().Filter(func(t *Tag) bool {
+		return t.Name == s
+	})
+}
+
+// Filter returns a new Search of the elements in Search for which f returns true.
+func (se Search) Filter(f func(*Tag) bool) Search {
+	var r Search
+
+	for _, v := range se {
+		if f(v) {
+			r = append(r, v)
+		}
+	}
+
+	return r
+}
+
+// children returns a new Search of the children Tags of each element in Search for which f returns true
+func (se Search) children(f func(*Tag) bool) Search {
 	var r Search
 
 	for _, v := range se {
 		for _, v2 := range v.Tags() {
-			if v2.Name == s {
+			if f(v2) {
 				r = append(r, v2)
 			}
 		}
@@ -18,10 +118,204 @@ func (se Search) ByName(s string) Search {
 	return r
 }
 
-// One returns the top result off of a Search
-func (se Search) One() *Tag {
+// ByAttribute searches through the children Tags of each element in Search looking for an Attribute with the given
+// name (including its prefix as written) and value, and returns a new Search of the results.
+func (se Search) ByAttribute(name, value string) Search {
+	return se.children(func(t *Tag) bool {
+		attr := t.Attr(name)
+		return attr != nil && attr.Value == value
+	})
+}
+
+// ByNamespace searches through the children Tags of each element in Search looking for matches of Name whose
+// namespace, resolved from the prefix through NamespaceURI, is uri, and returns a new Search of the results. Matches
+// do not depend on the prefix chosen by the producer of the document. Either uri or local may be '*' to match any
+// namespace or name, and an empty uri matches Tags in no namespace.
+func (se Search) ByNamespace(uri, local string) Search {
+	return se.children(func(t *Tag) bool {
+		return t.matchesNamespace(uri, local)
+	})
+}
+
+// DescendantsByNamespace works like ByNamespace, but searches all Tags below each element in Search rather than its
+// children.
+func (se Search) DescendantsByNamespace(uri, local string) Search {
+	return se.Descendants().Filter(func(t *Tag) bool {
+		return t.matchesNamespace(uri, local)
+	})
+}
+
+// matchesNamespace returns true if the Tag has the given namespace and local name, where '*' matches any
+func (t *Tag) matchesNamespace(uri, local string) bool {
+	if local != "*" && t.Name != local {
+		return false
+	}
+	if uri == "*" {
+		return true
+	}
+	ns, err := t.NamespaceURI()
+	return err == nil && ns == uri
+}
+
+// ByPrefix searches through the children Tags of each element in Search looking for case sensitive matches of
+// Prefix as written, and returns a new Search of the results.
+func (se Search) ByPrefix(prefix string) Search {
+	return se.children(func(t *Tag) bool {
+		return t.Prefix == prefix
+	})
+}
+
+// First returns the first element of Search, or nil if it is empty. It is the same as One.
+func (se Search) First() *Tag {
+	return se.One()
+}
+
+// Last returns the last element of Search, or nil if it is empty.
+func (se Search) Last() *Tag {
 	if len(se) > 0 {
-		return se[0]
+		return se[len(se)-1]
 	}
 	return nil
 }
+
+// Nth returns the element of Search at index i (starting at 0), or nil if i is out of range.
+func (se Search) Nth(i int) *Tag {
+	if i >= 0 && i < len(se) {
+		return se[i]
+	}
+	return nil
+}
+
+// Count returns the number of elements in Search.
+func (se Search) Count() int {
+	return len(se)
+}
+
+// Unique returns a new Search without the Tags that occur more than once, keeping the first occurrence of each.
+// Tags are compared by pointer.
+func (se Search) Unique() Search {
+	var r Search
+	seen := map[*Tag]bool{}
+
+	for _, v := range se {
+		if !seen[v] {
+			seen[v] = true
+			r = append(r, v)
+		}
+	}
+
+	return r
+}
+
+// Union returns a new Search of the elements of Search followed by those of other that are not in Search, without
+// duplicates. Use Sort to put the result in document order.
+func (se Search) Union(other Search) Search {
+	return append(append(Search{}, se...), other...).Unique()
+}
+
+// Intersect returns a new Search of the elements of Search that are also in other, without duplicates.
+func (se Search) Intersect(other Search) Search {
+	in := other.set()
+	return se.Filter(func(t *Tag) bool {
+		return in[t]
+	}).Unique()
+}
+
+// Except returns a new Search of the elements of Search that are not in other, without duplicates.
+func (se Search) Except(other Search) Search {
+	in := other.set()
+	return se.Filter(func(t *Tag) bool {
+		return !in[t]
+	}).Unique()
+}
+
+// set returns the Tags of Search as a set
+func (se Search) set() map[*Tag]bool {
+	r := make(map[*Tag]bool, len(se))
+	for _, v := range se {
+		r[v] = true
+	}
+	return r
+}
+
+// Each calls f with the index and value of each element in Search and returns Search unchanged.
+func (se Search) Each(f func(int, *Tag)) Search {
+	for k, v := range se {
+		f(k, v)
+	}
+	return se
+}
+
+// Values returns the Value of each element in Search. The first error returned by Value is returned.
+func (se Search) Values() ([]string, error) {
+	r := make([]string, 0, len(se))
+	for _, v := range se {
+		s, err := v.Value()
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, s)
+	}
+	return r, nil
+}
+
+// Attrs returns the value of the named Attribute (including its prefix as written) of each element in Search.
+// Elements without the Attribute are skipped.
+func (se Search) Attrs(name string) []string {
+	var r []string
+	for _, v := range se {
+		if attr := v.Attr(name); attr != nil {
+			r = append(r, attr.Value)
+		}
+	}
+	return r
+}
+
+// Parents returns a new Search of the parent Tag of each element in Search, without duplicates. Elements without a
+// parent are skipped.
+func (se Search) Parents() Search {
+	var r Search
+	for _, v := range se {
+		if p := v.Parent(); p != nil {
+			r = append(r, p)
+		}
+	}
+	return r.Unique()
+}
+
+// Sort returns a new Search of the elements of Search in document order, without duplicates. Tags from different
+// trees keep the order in which their trees first appear in Search.
+func (se Search) Sort() Search {
+	r := se.Unique()
+
+	type position struct {
+		tree int
+		path []int
+	}
+
+	trees := map[interface{}]int{}
+	positions := make(map[*Tag]position, len(r))
+	for _, v := range r {
+		top, path := v.documentPath()
+		if _, ok := trees[top]; !ok {
+			trees[top] = len(trees)
+		}
+		positions[v] = position{trees[top], path}
+	}
+
+	sort.SliceStable(r, func(i, j int) bool {
+		a, b := positions[r[i]], positions[r[j]]
+		if a.tree != b.tree {
+			return a.tree < b.tree
+		}
+		for k := 0; k < len(a.path) && k < len(b.path); k++ {
+			if a.path[k] != b.path[k] {
+				return a.path[k] < b.path[k]
+			}
+		}
+		// an ancestor comes before its descendants
+		return len(a.path) < len(b.path)
+	})
+
+	return r
+}
@@ -3,6 +3,10 @@ package simplexml
 import (
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+
+	"regexp"
+	"strconv"
+	"strings"
 )
 
 func TestByName(t *testing.T) {
@@ -39,3 +43,213 @@ func TestByName(t *testing.T) {
 		})
 	})
 }
+
+func TestDescendants(t *testing.T) {
+	Convey("Given a document with namespaced Tags at varying depths", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(`<feed xmlns="urn:atom" xmlns:m="urn:media"><entry id="1"><m:group><m:content url="a"/></m:group></entry><entry id="2"><m:content url="b"/><title/></entry></feed>`))
+		So(err, ShouldBeNil)
+		s := Search{d.Root()}
+
+		Convey("Descendants should return every Tag below in document order", func() {
+			names := []string{}
+			for _, v := range s.Descendants() {
+				names = append(names, v.Name)
+			}
+			So(names, ShouldResemble, []string{"entry", "group", "content", "entry", "content", "title"})
+		})
+
+		Convey("Descendants of overlapping results should not repeat Tags", func() {
+			So(len(append(s, s.ByName("entry")...).Descendants()), ShouldEqual, 6)
+		})
+
+		Convey("Descendants should be in document order when an ancestor follows its descendant", func() {
+			entry := s.ByName("entry").One()
+			names := []string{}
+			for _, v := range (Search{entry.Tags()[0], entry, d.Root()}).Descendants() {
+				names = append(names, v.Name)
+			}
+			So(names, ShouldResemble, []string{"entry", "group", "content", "entry", "content", "title"})
+		})
+
+		Convey("DescendantsByName should find Tags at any depth", func() {
+			So(len(s.DescendantsByName("content")), ShouldEqual, 2)
+			So(s.DescendantsByName("content").One().Attr("url").Value, ShouldEqual, "a")
+		})
+
+		Convey("Filter should keep the matching elements of the Search", func() {
+			r := s.Descendants().Filter(func(t *Tag) bool { return t.HasAttr("url") })
+			So(len(r), ShouldEqual, 2)
+		})
+
+		Convey("ByAttribute should match children by Attribute value", func() {
+			So(s.ByAttribute("id", "2").One(), ShouldEqual, s.ByName("entry")[1])
+			So(len(s.ByAttribute("id", "3")), ShouldEqual, 0)
+		})
+
+		Convey("ByNamespace and ByPrefix should match children by namespace or prefix", func() {
+			entries := s.ByName("entry")
+			So(len(entries.ByNamespace("urn:media", "content")), ShouldEqual, 1)
+			So(len(entries.ByNamespace("urn:atom", "title")), ShouldEqual, 1)
+			So(len(entries.ByNamespace("urn:atom", "content")), ShouldEqual, 0)
+			So(len(entries.ByPrefix("m")), ShouldEqual, 2)
+			So(len(s.ByNamespace("urn:atom", "entry")), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestSetOperations(t *testing.T) {
+	Convey("Given the results of different searches over one document", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(`<root><item id="1"><title>A</title></item><item id="2" type="video"><title>B</title></item><item id="3" type="video"><title>C</title></item></root>`))
+		So(err, ShouldBeNil)
+		items := d.Root().Search().ByName("item")
+		videos := d.Root().Search().ByAttribute("type", "video")
+		titles := d.Root().Search().DescendantsByName("title")
+
+		Convey("First, Last, Nth and Count should access elements", func() {
+			So(items.First(), ShouldPointTo, items[0])
+			So(items.Last(), ShouldPointTo, items[2])
+			So(items.Nth(1), ShouldPointTo, items[1])
+			So(items.Nth(3), ShouldBeNil)
+			So(items.Nth(-1), ShouldBeNil)
+			So(items.Count(), ShouldEqual, 3)
+			So(Search{}.Last(), ShouldBeNil)
+		})
+
+		Convey("Unique should remove repeated Tags", func() {
+			So(append(items, videos...).Unique().Attrs("id"), ShouldResemble, []string{"1", "2", "3"})
+		})
+
+		Convey("Union, Intersect and Except should combine results without duplicates", func() {
+			So(videos.Union(items).Attrs("id"), ShouldResemble, []string{"2", "3", "1"})
+			So(items.Intersect(videos).Attrs("id"), ShouldResemble, []string{"2", "3"})
+			So(items.Except(videos).Attrs("id"), ShouldResemble, []string{"1"})
+			So(append(items, items...).Except(nil).Count(), ShouldEqual, 3)
+		})
+
+		Convey("Sort should put Tags in document order", func() {
+			s := Search{titles[2], items[2], d.Root(), titles[0], items[0], titles[2]}.Sort()
+			So(s, ShouldResemble, Search{d.Root(), items[0], titles[0], items[2], titles[2]})
+			So(videos.Union(items).Sort().Attrs("id"), ShouldResemble, []string{"1", "2", "3"})
+		})
+
+		Convey("Sort should keep Tags of separate trees together", func() {
+			other := NewTag("other")
+			child := NewTag("child")
+			other.AddAfter(child, nil)
+			s := Search{child, titles[1], other, items[0]}.Sort()
+			So(s, ShouldResemble, Search{other, child, items[0], titles[1]})
+		})
+
+		Convey("Each should visit every element in order", func() {
+			var ids []string
+			items.Each(func(i int, t *Tag) {
+				ids = append(ids, strconv.Itoa(i)+":"+t.Attr("id").Value)
+			})
+			So(ids, ShouldResemble, []string{"0:1", "1:2", "2:3"})
+		})
+
+		Convey("Values and Attrs should map the results to strings", func() {
+			v, err := titles.Values()
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, []string{"A", "B", "C"})
+			So(items.Attrs("type"), ShouldResemble, []string{"video", "video"})
+		})
+
+		Convey("Parents should return each parent once", func() {
+			So(titles.Parents(), ShouldResemble, items)
+			So(items.Parents(), ShouldResemble, Search{d.Root()})
+			So(Search{d.Root()}.Parents(), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestNameMatching(t *testing.T) {
+	Convey("Given partner feeds that spell the same Tag differently", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(`<ads><ImpressionURL>a</ImpressionURL><impressionUrl>b</impressionUrl><impression_url>c</impression_url><clickUrl>d</clickUrl></ads>`))
+		So(err, ShouldBeNil)
+		s := d.Root().Search()
+
+		Convey("ByNameFold should match without regard to case", func() {
+			v, err := s.ByNameFold("impressionurl").Values()
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, []string{"a", "b"})
+		})
+
+		Convey("ByNameRegexp should match a regular expression", func() {
+			v, err := s.ByNameRegexp(regexp.MustCompile(`(?i)^impression_?url$`)).Values()
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, []string{"a", "b", "c"})
+		})
+
+		Convey("ByNameGlob should match a shell pattern", func() {
+			v, err := s.ByNameGlob("[Ii]mpression*").Values()
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, []string{"a", "b", "c"})
+			So(s.ByNameGlob("[").Count(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestNamespaceSearch(t *testing.T) {
+	Convey("Given RSS documents that mix in Atom under different prefixes", t, func() {
+		feeds := []string{
+			`<rss xmlns:atom="http://www.w3.org/2005/Atom"><channel><link>a</link><atom:link href="self"/></channel></rss>`,
+			`<rss xmlns:a="http://www.w3.org/2005/Atom"><channel><link>a</link><a:link href="self"/></channel></rss>`,
+			`<rss><channel><link>a</link><link xmlns="http://www.w3.org/2005/Atom" href="self"/></channel></rss>`,
+		}
+
+		for _, x := range feeds {
+			d, err := NewDocumentFromReader(strings.NewReader(x))
+			So(err, ShouldBeNil)
+			s := d.Root().Search()
+
+			Convey("ByNamespace should distinguish the Atom link from the RSS link in "+x, func() {
+				So(s.ByName("channel").ByNamespace("http://www.w3.org/2005/Atom", "link").Attrs("href"), ShouldResemble, []string{"self"})
+				v, err := s.ByName("channel").ByNamespace("", "link").Values()
+				So(err, ShouldBeNil)
+				So(v, ShouldResemble, []string{"a"})
+			})
+
+			Convey("DescendantsByNamespace should search at any depth in "+x, func() {
+				So(s.DescendantsByNamespace("http://www.w3.org/2005/Atom", "link").Count(), ShouldEqual, 1)
+				So(s.DescendantsByNamespace("*", "link").Count(), ShouldEqual, 2)
+				So(s.DescendantsByNamespace("http://www.w3.org/2005/Atom", "*").Count(), ShouldEqual, 1)
+				So(s.DescendantsByNamespace("", "*").Count(), ShouldEqual, 2)
+			})
+		}
+	})
+
+	Convey("Given a Tag with a prefix that is not declared", t, func() {
+		tag := NewTag("link")
+		tag.Prefix = "atom"
+		s := NewTag("channel")
+		s.AddAfter(tag, nil)
+
+		Convey("NamespaceURI should return an error", func() {
+			_, err := tag.NamespaceURI()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("ByNamespace should only match it with the wildcard", func() {
+			So(s.Search().ByNamespace("", "link").Count(), ShouldEqual, 0)
+			So(s.Search().ByNamespace("*", "link").One(), ShouldPointTo, tag)
+		})
+	})
+
+	Convey("Given an unprefixed Tag whose default namespace is declared more than once", t, func() {
+		tag := NewTag("link")
+		tag.AddAttribute("xmlns", "urn:a", "").AddAttribute("xmlns", "urn:b", "")
+		s := NewTag("channel")
+		s.AddAfter(tag, nil)
+
+		Convey("NamespaceURI should return an error", func() {
+			_, err := tag.NamespaceURI()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("ByNamespace should not match it as a Tag in no namespace", func() {
+			So(s.Search().ByNamespace("", "link").Count(), ShouldEqual, 0)
+			So(s.Search().ByNamespace("*", "link").One(), ShouldPointTo, tag)
+		})
+	})
+}
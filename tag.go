@@ -1,10 +1,16 @@
 package simplexml
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Tag is an Element that can contain multiple child Elements
@@ -21,30 +27,60 @@ type Tag struct {
 	// elements is a slice of interface, gaurenteed to be a pointer through AddBefore and AddAfter
 	elements []Element
 
-	// parents is a slice of *Tag in order of parent to child relationship. It is used to build an XPath, determine
-	// indent depth for MarshalIndent() and find available namespaces.
-	parents []*Tag
+	// parent is the Tag that contains this Tag, nil for a top level or detached Tag. It is maintained by the
+	// methods that add and remove elements and is used to build an XPath and find available namespaces.
+	parent *Tag
+
+	// document is the Document that contains this Tag as a top level element
+	document *Document
 
 	// formatPrefix is the prefix value used during MarshalIndent
 	formatPrefix string
 
 	// formatIndent is the indent value used during MarshalIndent
 	formatIndent string
+
+	// formatDepth is the number of times formatIndent is repeated before the Tag during MarshalIndent
+	formatDepth int
 }
 
-// setIndent recursively sets a Tags prefix and indent values for use during MarshalIndent
-func (t *Tag) setIndent(indent string, prefix string) {
+// setIndent recursively sets a Tags prefix, indent and depth values for use during MarshalIndent. The children of a
+// Tag with mixed content (any Value or CDATA element) are not indented, as doing so would alter its value.
+func (t *Tag) setIndent(prefix string, indent string, depth int) {
 	t.formatPrefix = prefix
 	t.formatIndent = indent
+	t.formatDepth = depth
 	for _, v := range t.Tags() {
-		v.setIndent(prefix, indent)
+		if t.isMixed() {
+			v.setIndent("", "", 0)
+		} else {
+			v.setIndent(prefix, indent, depth+1)
+		}
 	}
 }
 
+// isMixed returns true if the Tag contains any element other than a Tag, Comment or ProcInst
+func (t Tag) isMixed() bool {
+	for _, v := range t.elements {
+		switch v.(type) {
+		case *Tag, *Comment, *ProcInst:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// lineIndent returns the prefix and indent to be written at the start of a line at the given depth
+func (t Tag) lineIndent(depth int) string {
+	return t.formatPrefix + strings.Repeat(t.formatIndent, depth)
+}
+
 // AddBefore takes an Element pointer (add) and an optional Element pointer (before).
 // If before == nil, the add element will be prepended to the elements slice, otherwise it will be placed
 // before the 'before' element. If 'before' != nil and is not found in the current Tags elements, an error
-// will be returned. This method is not recursive.
+// will be returned. This method is not recursive. A Tag that is already part of a tree is moved rather than shared, an
+// error is returned if add is the current Tag or one of its ancestors.
 func (t *Tag) AddBefore(add Element, before Element) error {
 	// verify add is pointer
 	if reflect.ValueOf(add).Kind() != reflect.Ptr {
@@ -53,26 +89,26 @@ func (t *Tag) AddBefore(add Element, before Element) error {
 
 	if before != nil {
 		// verify before is pointer
-		refBefore := reflect.ValueOf(before)
-		if refBefore.Kind() != reflect.Ptr {
+		if reflect.ValueOf(before).Kind() != reflect.Ptr {
 			return errors.New("non-pointer 'before' passed to AddBefore")
 		}
 
 		// find address in current tag
-		loc := -1
-		for k, v := range t.elements {
-			if refBefore.Pointer() == reflect.ValueOf(v).Pointer() {
-				loc = k
-				break
-			}
-		}
-
-		if loc >= 0 {
-			// add the new element before the matched element in the slice
-			t.elements = append(t.elements[:loc], append([]Element{add}, t.elements[loc:]...)...)
-		} else {
+		if elementIndex(t.elements, before) < 0 {
 			return errors.New("memory address of 'before' not in current Tag")
+		} else if samePointer(add, before) {
+			return nil
 		}
+	}
+
+	if err := adopt(add, t, nil); err != nil {
+		return err
+	}
+
+	if before != nil {
+		// add the new element before the matched element in the slice
+		loc := elementIndex(t.elements, before)
+		t.elements = append(t.elements[:loc], append([]Element{add}, t.elements[loc:]...)...)
 	} else {
 		// prepend to elements
 		t.elements = append([]Element{add}, t.elements...)
@@ -84,7 +120,8 @@ func (t *Tag) AddBefore(add Element, before Element) error {
 // AddAfter takes an Element pointer (add) and an optional Element pointer (after).
 // If before == nil, the add element will be appended to the elements slice, otherwise it will be placed
 // after the 'after' element. If 'after' != nil and is not found in the current Tags elements, an error
-// will be returned. This method is not recursive.
+// will be returned. This method is not recursive. A Tag that is already part of a tree is moved rather than shared, an
+// error is returned if add is the current Tag or one of its ancestors.
 func (t *Tag) AddAfter(add Element, after Element) error {
 	// verify add is pointer
 	if reflect.ValueOf(add).Kind() != reflect.Ptr {
@@ -93,26 +130,26 @@ func (t *Tag) AddAfter(add Element, after Element) error {
 
 	if after != nil {
 		// verify after is pointer
-		refAfter := reflect.ValueOf(after)
-		if refAfter.Kind() != reflect.Ptr {
+		if reflect.ValueOf(after).Kind() != reflect.Ptr {
 			return errors.New("non-pointer 'after' passed to AddBefore")
 		}
 
 		// find address in current tag
-		loc := -1
-		for k, v := range t.elements {
-			if refAfter.Pointer() == reflect.ValueOf(v).Pointer() {
-				loc = k
-				break
-			}
-		}
-
-		if loc >= 0 {
-			// add the new element after the matched element in the slice
-			t.elements = append(t.elements[:loc+1], append([]Element{add}, t.elements[loc+1:]...)...)
-		} else {
+		if elementIndex(t.elements, after) < 0 {
 			return errors.New("memory address of 'after' not in current Tag")
+		} else if samePointer(add, after) {
+			return nil
 		}
+	}
+
+	if err := adopt(add, t, nil); err != nil {
+		return err
+	}
+
+	if after != nil {
+		// add the new element after the matched element in the slice
+		loc := elementIndex(t.elements, after)
+		t.elements = append(t.elements[:loc+1], append([]Element{add}, t.elements[loc+1:]...)...)
 	} else {
 		// append to elements
 		t.elements = append(t.elements, add)
@@ -125,23 +162,17 @@ func (t *Tag) AddAfter(add Element, after Element) error {
 // memory address is not an element of the Tag. This function is not recursive.
 func (t *Tag) Remove(remove Element) error {
 	// verify remove is pointer
-	refRemove := reflect.ValueOf(remove)
-	if refRemove.Kind() != reflect.Ptr {
+	if reflect.ValueOf(remove).Kind() != reflect.Ptr {
 		return errors.New("non-pointer 'remove' passed to Remove")
 	}
 
 	// find address in current tag
-	loc := -1
-	for k, v := range t.elements {
-		if refRemove.Pointer() == reflect.ValueOf(v).Pointer() {
-			loc = k
-			break
-		}
-	}
-
-	if loc >= 0 {
+	if loc := elementIndex(t.elements, remove); loc >= 0 {
 		// delete the matched element from the slice
 		t.elements = append(t.elements[:loc], t.elements[loc+1:]...)
+		if tag, ok := remove.(*Tag); ok {
+			tag.parent, tag.document = nil, nil
+		}
 	} else {
 		return errors.New("memory address of 'remove' not in current Tag")
 	}
@@ -149,6 +180,350 @@ func (t *Tag) Remove(remove Element) error {
 	return nil
 }
 
+// ReplaceWith replaces the element with the memory address of old by replacement, which is moved from its current
+// position if it is a Tag already part of a tree. An error will be returned if old is not an element of the Tag or
+// replacement is the current Tag or one of its ancestors. This method is not recursive.
+func (t *Tag) ReplaceWith(old Element, replacement Element) error {
+	// verify old and replacement are pointers
+	if reflect.ValueOf(old).Kind() != reflect.Ptr {
+		return errors.New("non-pointer 'old' passed to ReplaceWith")
+	} else if reflect.ValueOf(replacement).Kind() != reflect.Ptr {
+		return errors.New("non-pointer 'replacement' passed to ReplaceWith")
+	}
+
+	// find address in current tag
+	if elementIndex(t.elements, old) < 0 {
+		return errors.New("memory address of 'old' not in current Tag")
+	} else if samePointer(old, replacement) {
+		return nil
+	}
+
+	if err := adopt(replacement, t, nil); err != nil {
+		return err
+	}
+
+	loc := elementIndex(t.elements, old)
+	t.elements[loc] = replacement
+	if tag, ok := old.(*Tag); ok {
+		tag.parent, tag.document = nil, nil
+	}
+
+	return nil
+}
+
+// Wrap replaces the element with the memory address of e by wrapper and appends e to the elements of wrapper. An
+// error will be returned if e is not an element of the Tag, or if wrapping would make a Tag its own descendant.
+func (t *Tag) Wrap(e Element, wrapper *Tag) error {
+	// verify e is pointer
+	if reflect.ValueOf(e).Kind() != reflect.Ptr {
+		return errors.New("non-pointer 'e' passed to Wrap")
+	} else if wrapper == nil {
+		return errors.New("nil 'wrapper' passed to Wrap")
+	}
+
+	// find address in current tag
+	if elementIndex(t.elements, e) < 0 {
+		return errors.New("memory address of 'e' not in current Tag")
+	}
+
+	// wrapper can not be e, the current Tag, one of its ancestors or within e
+	if tag, ok := e.(*Tag); ok {
+		for p := wrapper; p != nil; p = p.parent {
+			if p == tag {
+				return errors.New("a Tag can not be wrapped by itself or its descendants")
+			}
+		}
+	}
+	for p := t; p != nil; p = p.parent {
+		if p == wrapper {
+			return errors.New("a Tag can not be added to itself or its descendants")
+		}
+	}
+
+	if err := t.ReplaceWith(e, wrapper); err != nil {
+		return err
+	}
+	return wrapper.AddAfter(e, nil)
+}
+
+// Unwrap replaces the child Tag with the memory address of tag by its elements. An error will be returned if tag
+// is not an element of the Tag. The unwrapped Tag is left detached and empty.
+func (t *Tag) Unwrap(tag *Tag) error {
+	loc := elementIndex(t.elements, tag)
+	if tag == nil || loc < 0 {
+		return errors.New("memory address of 'tag' not in current Tag")
+	}
+
+	children := tag.elements
+	for _, v := range children {
+		if c, ok := v.(*Tag); ok {
+			c.parent = t
+		}
+	}
+
+	t.elements = append(t.elements[:loc], append(children, t.elements[loc+1:]...)...)
+	tag.elements = nil
+	tag.parent, tag.document = nil, nil
+
+	return nil
+}
+
+// MoveBefore moves the element move before the 'before' element of the Tag, or to the start of the Tag if before
+// == nil. move may be a Tag anywhere in a tree or any element of the current Tag. An error will be returned if
+// 'before' != nil and is not an element of the Tag, or if move is another element that can not be removed from its
+// current position.
+func (t *Tag) MoveBefore(move Element, before Element) error {
+	if err := t.prepareMove(move, before); err != nil {
+		return err
+	}
+	return t.AddBefore(move, before)
+}
+
+// MoveAfter moves the element move after the 'after' element of the Tag, or to the end of the Tag if after ==
+// nil. move may be a Tag anywhere in a tree or any element of the current Tag. An error will be returned if 'after'
+// != nil and is not an element of the Tag, or if move is another element that can not be removed from its current
+// position.
+func (t *Tag) MoveAfter(move Element, after Element) error {
+	if err := t.prepareMove(move, after); err != nil {
+		return err
+	}
+	return t.AddAfter(move, after)
+}
+
+// prepareMove validates a move relative to the element at and removes move from the Tag if it is not a Tag, which
+// AddBefore and AddAfter detach themselves
+func (t *Tag) prepareMove(move Element, at Element) error {
+	// verify move and at are pointers
+	if reflect.ValueOf(move).Kind() != reflect.Ptr {
+		return errors.New("non-pointer 'move' passed to Move")
+	} else if at != nil && reflect.ValueOf(at).Kind() != reflect.Ptr {
+		return errors.New("non-pointer position passed to Move")
+	}
+
+	if at != nil && elementIndex(t.elements, at) < 0 {
+		return errors.New("memory address of position not in current Tag")
+	}
+
+	if _, ok := move.(*Tag); ok || at != nil && samePointer(move, at) {
+		return nil
+	}
+
+	// elements other than Tags do not know their parent
+	if elementIndex(t.elements, move) < 0 {
+		return errors.New("memory address of 'move' not in current Tag")
+	}
+	return t.Remove(move)
+}
+
+// ReplaceChildren replaces all elements of the Tag with the given elements, moving Tags that are already part of a
+// tree. An error will be returned, leaving the Tag unchanged, if any element is not a pointer or is the current Tag
+// or one of its ancestors.
+func (t *Tag) ReplaceChildren(elements ...Element) error {
+	for _, e := range elements {
+		if reflect.ValueOf(e).Kind() != reflect.Ptr {
+			return errors.New("non-pointer element passed to ReplaceChildren")
+		}
+		if tag, ok := e.(*Tag); ok {
+			for p := t; p != nil; p = p.parent {
+				if p == tag {
+					return errors.New("a Tag can not be added to itself or its descendants")
+				}
+			}
+		}
+	}
+
+	for _, v := range t.elements {
+		if tag, ok := v.(*Tag); ok {
+			tag.parent = nil
+		}
+	}
+	t.elements = nil
+
+	for _, e := range elements {
+		if err := t.AddAfter(e, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Parent returns the Tag containing the current Tag, or nil if it is a top level or detached Tag.
+func (t Tag) Parent() *Tag {
+	return t.parent
+}
+
+// Ancestors returns the parent of the current Tag, its parent and so on up to the top level Tag.
+func (t Tag) Ancestors() []*Tag {
+	var s []*Tag
+	for p := t.parent; p != nil; p = p.parent {
+		s = append(s, p)
+	}
+	return s
+}
+
+// Document returns the Document containing the current Tag or any of its ancestors, or nil if the tree is not part
+// of a Document.
+func (t Tag) Document() *Document {
+	top := &t
+	for top.parent != nil {
+		top = top.parent
+	}
+	return top.document
+}
+
+// lineage returns the ancestors of the current Tag, top level first
+func (t Tag) lineage() []*Tag {
+	s := t.Ancestors()
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+	return s
+}
+
+// documentPath returns the top of the tree holding t (its Document, or its top Tag if it is not in one) and the index
+// of t and each of its ancestors among the elements of their container, top first
+func (t *Tag) documentPath() (interface{}, []int) {
+	var path []int
+	c := t
+	for ; c.parent != nil; c = c.parent {
+		path = append(path, elementIndex(c.parent.elements, c))
+	}
+
+	var top interface{} = c
+	if c.document != nil {
+		top = c.document
+		path = append(path, elementIndex(c.document.elements, c))
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return top, path
+}
+
+// adopt prepares add to be inserted as a child of parent, or as a top level element of doc. A Tag is detached from
+// its current position first, an error is returned if the Tag is parent or one of its ancestors.
+func adopt(add Element, parent *Tag, doc *Document) error {
+	tag, ok := add.(*Tag)
+	if !ok {
+		return nil
+	}
+
+	for p := parent; p != nil; p = p.parent {
+		if p == tag {
+			return errors.New("a Tag can not be added to itself or its descendants")
+		}
+	}
+
+	tag.detach()
+	tag.parent, tag.document = parent, doc
+	return nil
+}
+
+// detach removes the Tag from its parent or Document, if any
+func (t *Tag) detach() {
+	if t.parent != nil {
+		t.parent.Remove(t)
+	} else if t.document != nil {
+		t.document.Remove(t)
+	}
+}
+
+// Clone returns a deep copy of the Tag, its Attributes and all of its child Elements. The copy is detached, with
+// Parent() and Document() returning nil, while its children have the copy as their parent. The namespace
+// declarations of ancestors that the copied Tags and Attributes depend on are added to the copy, so that it resolves
+// the same namespaces on its own.
+func (t Tag) Clone() *Tag {
+	c := t.clone()
+
+	for _, prefix := range c.undeclaredPrefixes(nil) {
+		ns, err := t.GetNamespace(prefix)
+		if err != nil || prefix == "" && ns == "" {
+			continue
+		}
+
+		if prefix == "" {
+			c.Attributes = append(c.Attributes, &Attribute{Name: "xmlns", Value: ns})
+		} else {
+			c.AddNamespace(prefix, ns)
+		}
+	}
+
+	return c
+}
+
+// undeclaredPrefixes returns the prefixes used by the Tag, its Attributes and its descendants that are not declared
+// within the Tag or in declared, in order of first use. An empty prefix stands for the default namespace.
+func (t *Tag) undeclaredPrefixes(declared map[string]bool) []string {
+	scope := map[string]bool{"xml": true, "xmlns": true}
+	for k := range declared {
+		scope[k] = true
+	}
+	for _, attr := range t.Attributes {
+		if attr.IsNamespace() {
+			scope[attr.declaredPrefix()] = true
+		}
+	}
+
+	var r []string
+	use := func(prefix string) {
+		if !scope[prefix] {
+			scope[prefix] = true
+			r = append(r, prefix)
+		}
+	}
+
+	use(t.Prefix)
+	for _, attr := range t.Attributes {
+		if attr.Prefix != "" && !attr.IsNamespace() {
+			use(attr.Prefix)
+		}
+	}
+
+	for _, v := range t.Tags() {
+		for _, prefix := range v.undeclaredPrefixes(scope) {
+			use(prefix)
+		}
+	}
+	return r
+}
+
+// clone returns a deep copy of the Tag without adding namespace declarations
+func (t Tag) clone() *Tag {
+	c := &Tag{Name: t.Name, Prefix: t.Prefix}
+
+	if t.Attributes != nil {
+		c.Attributes = make([]*Attribute, len(t.Attributes))
+		for k, v := range t.Attributes {
+			attr := *v
+			c.Attributes[k] = &attr
+		}
+	}
+
+	for _, v := range t.elements {
+		e := cloneElement(v)
+		if tag, ok := e.(*Tag); ok {
+			tag.parent = c
+		}
+		c.elements = append(c.elements, e)
+	}
+
+	return c
+}
+
+// cloneElement returns a deep copy of a Tag, or a copy of the value pointed to by any other Element
+func cloneElement(e Element) Element {
+	if t, ok := e.(*Tag); ok {
+		return t.clone()
+	}
+
+	v := reflect.ValueOf(e)
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	return c.Interface().(Element)
+}
+
 // Elements returns a slice of the Tags child Elements
 func (t Tag) Elements() []Element {
 	var s []Element
@@ -174,6 +549,71 @@ func (t Tag) Tags() []*Tag {
 	return s
 }
 
+// FirstChild returns the first child Element of the Tag, or nil if it is empty.
+func (t Tag) FirstChild() Element {
+	return elementAt(t.elements, 0)
+}
+
+// LastChild returns the last child Element of the Tag, or nil if it is empty.
+func (t Tag) LastChild() Element {
+	return elementAt(t.elements, len(t.elements)-1)
+}
+
+// ChildAt returns the child Element at index i, or nil if i is out of range.
+func (t Tag) ChildAt(i int) Element {
+	return elementAt(t.elements, i)
+}
+
+// IndexOf returns the index of the child Element with the same memory address as e, or -1 if it is not a child.
+func (t Tag) IndexOf(e Element) int {
+	return elementIndex(t.elements, e)
+}
+
+// NextSibling returns the child Element following e, or nil if e is the last child or not a child.
+func (t Tag) NextSibling(e Element) Element {
+	return siblingElement(t.elements, e, 1)
+}
+
+// PreviousSibling returns the child Element preceding e, or nil if e is the first child or not a child.
+func (t Tag) PreviousSibling(e Element) Element {
+	return siblingElement(t.elements, e, -1)
+}
+
+// FirstChildTag returns the first child Tag, or nil if there are none.
+func (t Tag) FirstChildTag() *Tag {
+	return tagAt(t.elements, 0)
+}
+
+// LastChildTag returns the last child Tag, or nil if there are none.
+func (t Tag) LastChildTag() *Tag {
+	for i := len(t.elements) - 1; i >= 0; i-- {
+		if tag, ok := t.elements[i].(*Tag); ok {
+			return tag
+		}
+	}
+	return nil
+}
+
+// ChildTagAt returns the child Tag at index i of Tags(), or nil if i is out of range.
+func (t Tag) ChildTagAt(i int) *Tag {
+	return tagAt(t.elements, i)
+}
+
+// IndexOfTag returns the index of tag within Tags(), or -1 if it is not a child.
+func (t Tag) IndexOfTag(tag *Tag) int {
+	return tagIndex(t.elements, tag)
+}
+
+// NextSiblingTag returns the first child Tag following e, or nil if there is none or e is not a child.
+func (t Tag) NextSiblingTag(e Element) *Tag {
+	return siblingTag(t.elements, e, 1)
+}
+
+// PreviousSiblingTag returns the last child Tag preceding e, or nil if there is none or e is not a child.
+func (t Tag) PreviousSiblingTag(e Element) *Tag {
+	return siblingTag(t.elements, e, -1)
+}
+
 // AvailableNamespaces returns a slice of Attribute from the current Tag and it's
 // parents in which IsNamespace() returns true
 func (t Tag) AvailableNamespaces() []Attribute {
@@ -187,7 +627,7 @@ func (t Tag) AvailableNamespaces() []Attribute {
 	}
 
 	// check parent attributes
-	for _, pt := range t.parents {
+	for _, pt := range t.lineage() {
 		for _, attr := range pt.Attributes {
 			if attr.IsNamespace() {
 				namespaces = append(namespaces, *attr)
@@ -197,37 +637,38 @@ func (t Tag) AvailableNamespaces() []Attribute {
 	return namespaces
 }
 
-// GetPrefix iterates through AvailableNamespaces() and returns a prefix string for the given namespace. An error
-// is returned upon 0 or more than 1 result.
+// GetPrefix returns the prefix bound to the given namespace by the nearest declaration on the current Tag or its
+// parents, or an empty string for a default namespace (xmlns="..."). An error is returned upon 0 results or if the
+// nearest declaring Tag binds the namespace more than once.
 func (t Tag) GetPrefix(ns string) (string, error) {
-	var r []Attribute
-
-	for _, attr := range t.AvailableNamespaces() {
-		if attr.IsNamespace() && attr.Value == ns {
-			r = append(r, attr)
+	for _, scope := range t.scopes() {
+		var r []*Attribute
+		for _, attr := range scope.Attributes {
+			if attr.IsNamespace() && attr.Value == ns {
+				r = append(r, attr)
+			}
 		}
-	}
 
-	if len(r) == 1 {
-		return r[0].Name, nil
-	} else if len(r) > 1 {
-		return "", errors.New(fmt.Sprintf("prefix for namespace '%s' defined more than once", ns))
+		if len(r) == 1 {
+			return r[0].declaredPrefix(), nil
+		} else if len(r) > 1 {
+			return "", errors.New(fmt.Sprintf("prefix for namespace '%s' defined more than once", ns))
+		}
 	}
 
 	return "", errors.New(fmt.Sprintf("prefix for namespace '%s' not available", ns))
 }
 
-// GetNamespace iterates through AvailableNamespaces() and returns a namespace string for the given prefix. An error
-// is returned upon 0 or more than 1 result.
+// GetNamespace returns the namespace bound to the given prefix by the nearest declaration on the current Tag or its
+// parents. An empty prefix returns the default namespace and the predefined 'xml' prefix always returns
+// XMLNamespace. An error is returned upon 0 results or if the nearest declaring Tag declares the prefix more than
+// once.
 func (t Tag) GetNamespace(prefix string) (string, error) {
-	var r []Attribute
-
-	for _, attr := range t.AvailableNamespaces() {
-		if attr.IsNamespace() && attr.Name == prefix {
-			r = append(r, attr)
-		}
+	if prefix == "xml" {
+		return XMLNamespace, nil
 	}
 
+	r := t.namespaceDeclarations(prefix)
 	if len(r) == 1 {
 		return r[0].Value, nil
 	} else if len(r) > 1 {
@@ -237,181 +678,605 @@ func (t Tag) GetNamespace(prefix string) (string, error) {
 	return "", errors.New(fmt.Sprintf("namespace for prefix '%s' not available", prefix))
 }
 
-// AddAttribute appends a new Attribute to the Tag.
+// namespaceDeclarations returns the declarations of prefix on the nearest Tag (the current Tag or its parents) that
+// declares it, or nil if there are none
+func (t Tag) namespaceDeclarations(prefix string) []*Attribute {
+	for _, scope := range t.scopes() {
+		var r []*Attribute
+		for _, attr := range scope.Attributes {
+			if attr.IsNamespace() && attr.declaredPrefix() == prefix {
+				r = append(r, attr)
+			}
+		}
+
+		if len(r) > 0 {
+			return r
+		}
+	}
+	return nil
+}
+
+// NamespaceURI returns the namespace of the Tag, resolved from its Prefix through GetNamespace. An unprefixed Tag
+// is in the default namespace, or in no namespace (an empty string) if none is declared. An error is returned if
+// the Prefix can not be resolved or the namespace is declared more than once.
+func (t Tag) NamespaceURI() (string, error) {
+	if t.Prefix == "" && t.namespaceDeclarations("") == nil {
+		return "", nil
+	}
+	return t.GetNamespace(t.Prefix)
+}
+
+// scopes returns the current Tag followed by its parents, nearest first
+func (t Tag) scopes() []*Tag {
+	return append([]*Tag{&t}, t.Ancestors()...)
+}
+
+// AddAttribute appends a new Attribute to the Tag, see SetAttr to replace an existing Attribute instead.
 func (t *Tag) AddAttribute(name string, value string, prefix string) *Tag {
-	t.Attributes = append(t.Attributes, &Attribute{prefix, name, value})
+	t.Attributes = append(t.Attributes, &Attribute{Prefix: prefix, Name: name, Value: value})
 	return t
 }
 
 // AddNamespace is a wrapper for AddAttribute, setting the prefix to 'xmlns'.
 func (t *Tag) AddNamespace(name string, value string) *Tag {
-	t.Attributes = append(t.Attributes, &Attribute{"xmlns", name, value})
+	t.Attributes = append(t.Attributes, &Attribute{Prefix: "xmlns", Name: name, Value: value})
+	return t
+}
+
+// Attr returns the Attribute with the given name, which includes the prefix as written (eg. 'xml:lang'), or nil if
+// the Tag does not have one.
+func (t Tag) Attr(name string) *Attribute {
+	prefix, local := splitName(name)
+	for _, attr := range t.Attributes {
+		if attr.Prefix == prefix && attr.Name == local {
+			return attr
+		}
+	}
+	return nil
+}
+
+// AttrNS returns the Attribute with the given local name whose prefix resolves to namespace through GetNamespace,
+// or nil if the Tag does not have one. Attributes without a prefix are not in any namespace and are returned for an
+// empty namespace.
+func (t Tag) AttrNS(namespace, name string) *Attribute {
+	for _, attr := range t.Attributes {
+		if attr.Name != name || attr.IsNamespace() {
+			continue
+		}
+
+		if attr.Prefix == "" {
+			if namespace == "" {
+				return attr
+			}
+			continue
+		}
+
+		if ns, err := t.GetNamespace(attr.Prefix); err == nil && ns == namespace {
+			return attr
+		}
+	}
+	return nil
+}
+
+// HasAttr returns true if the Tag has an Attribute with the given name.
+func (t Tag) HasAttr(name string) bool {
+	return t.Attr(name) != nil
+}
+
+// SetAttr sets the value of the Attribute with the given name, which may include a prefix (eg. 'xml:lang'), or
+// appends a new Attribute if the Tag does not have one.
+func (t *Tag) SetAttr(name string, value string) *Tag {
+	if attr := t.Attr(name); attr != nil {
+		attr.Value = value
+		return t
+	}
+
+	prefix, local := splitName(name)
+	t.Attributes = append(t.Attributes, &Attribute{Prefix: prefix, Name: local, Value: value})
 	return t
 }
 
-/*
-TODO: Removed from scope of v0.1
+// RemoveAttr removes the Attribute with the given name and returns true if the Tag had one.
+func (t *Tag) RemoveAttr(name string) bool {
+	attr := t.Attr(name)
+	if attr == nil {
+		return false
+	}
+
+	for k, v := range t.Attributes {
+		if v == attr {
+			t.Attributes = append(t.Attributes[:k], t.Attributes[k+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// AttrInt returns the value of the Attribute with the given name as an int. An error is returned if the Tag does
+// not have the Attribute or its value is not an integer.
+func (t Tag) AttrInt(name string) (int, error) {
+	v, err := t.attrValue(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(v))
+}
+
+// AttrFloat returns the value of the Attribute with the given name as a float64. An error is returned if the Tag
+// does not have the Attribute or its value is not a number.
+func (t Tag) AttrFloat(name string) (float64, error) {
+	v, err := t.attrValue(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(v), 64)
+}
+
+// AttrBool returns the value of the Attribute with the given name as a bool ('true', 'false', '1' or '0'). An error
+// is returned if the Tag does not have the Attribute or its value is not a boolean.
+func (t Tag) AttrBool(name string) (bool, error) {
+	v, err := t.attrValue(name)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := parseBool(v)
+	if !ok {
+		return false, fmt.Errorf("attribute '%s' value '%s' is not a boolean", name, v)
+	}
+	return b, nil
+}
+
+// attrValue returns the value of the Attribute with the given name or an error if the Tag does not have one
+func (t Tag) attrValue(name string) (string, error) {
+	attr := t.Attr(name)
+	if attr == nil {
+		return "", fmt.Errorf("attribute '%s' not found", name)
+	}
+	return attr.Value, nil
+}
+
+// splitName splits a name into its prefix and local name
+func splitName(name string) (string, string) {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
 
 // XPath returns the Tag's XPath from it's root
 func (t Tag) XPath() XPath {
 	x := XPath{}
 
-	for _, v := range t.parents {
-		x = append(x, v.Name)
+	for _, v := range t.lineage() {
+		x = append(x, v.qualifiedName())
 	}
 
-	return append(x, t.Name)
+	return append(x, t.qualifiedName())
 }
-*/
-// innerValue returns a string representation of the inner contents of a Tag
-func (t Tag) innerValue() string {
-	var s string
 
+// hasContent returns true if writing the inner contents of the Tag would produce any output
+func (t Tag) hasContent() bool {
 	for _, e := range t.elements {
-		s = s + e.String()
+		if v, ok := e.(*Value); !ok || *v != "" {
+			return true
+		}
 	}
-
-	return s
+	return false
 }
 
-// Value returns the inner value of a non Comment and non Tag element. Value will return an
-// empty string if there are 0 and an error if there are > 1.
+// Value returns the inner value of a non Comment and non Tag element. Adjacent Value, CDATA, EntityRef and
+// Whitespace elements are joined into a single value. Whitespace that is not adjacent to any other value is ignored
+// unless the Tag contains nothing else. Value will return an empty string if there are 0 and an error if there
+// are > 1 values separated by a Tag or Comment.
 func (t Tag) Value() (string, error) {
-	var el []Element
+	var values []string
+	var space string
+	var open, other bool
 
 	for _, v := range t.elements {
-		switch ty := v.(type) {
-		case *Tag:
-		case *Comment:
+		switch k := v.(type) {
+		case *Tag, *Comment, *ProcInst, *Doctype:
+			open = false
+			other = true
+			space = ""
+		case *Whitespace:
+			if open {
+				values[len(values)-1] += string(*k)
+			} else {
+				space += string(*k)
+			}
 		default:
-			el = append(el, ty)
+			s, err := v.Value()
+			if err != nil {
+				return "", err
+			}
+
+			if open {
+				values[len(values)-1] += s
+			} else {
+				values = append(values, space+s)
+				open = true
+			}
+			space = ""
 		}
 	}
 
-	if len(el) > 1 {
+	if len(values) > 1 {
 		return "", errors.New("multiple value type elements found in tag")
-	} else if len(el) == 0 {
+	} else if len(values) == 0 {
+		if !other {
+			return space, nil
+		}
 		return "", nil
 	}
 
-	return el[0].Value()
+	return values[0], nil
+}
+
+// TextContent returns the values of all Value, CDATA, EntityRef and Whitespace elements within the Tag and its
+// descendants joined in document order, like textContent in the DOM. Comments and processing instructions are
+// skipped.
+func (t Tag) TextContent() string {
+	var b strings.Builder
+	t.textContent(&b)
+	return b.String()
+}
+
+func (t Tag) textContent(b *strings.Builder) {
+	for _, v := range t.elements {
+		switch k := v.(type) {
+		case *Tag:
+			k.textContent(b)
+		case *Value, *CDATA, *EntityRef, *Whitespace:
+			s, _ := k.Value()
+			b.WriteString(s)
+		}
+	}
+}
+
+// IntValue returns Value() as an int, ignoring surrounding whitespace.
+func (t Tag) IntValue() (int, error) {
+	v, err := t.Value()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(v))
+}
+
+// FloatValue returns Value() as a float64, ignoring surrounding whitespace.
+func (t Tag) FloatValue() (float64, error) {
+	v, err := t.Value()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(v), 64)
+}
+
+// BoolValue returns Value() as a bool ('true', 'false', '1' or '0'), ignoring surrounding whitespace.
+func (t Tag) BoolValue() (bool, error) {
+	v, err := t.Value()
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := parseBool(v)
+	if !ok {
+		return false, fmt.Errorf("value '%s' is not a boolean", v)
+	}
+	return b, nil
+}
+
+// DurationValue returns Value() as a time.Duration, ignoring surrounding whitespace. Both the format of
+// time.ParseDuration ('1m30s') and a clock format ('HH:MM:SS' with optional fractional seconds, as used by VAST) are
+// accepted.
+func (t Tag) DurationValue() (time.Duration, error) {
+	v, err := t.Value()
+	if err != nil {
+		return 0, err
+	}
+
+	v = strings.TrimSpace(v)
+	if !strings.Contains(v, ":") {
+		return time.ParseDuration(v)
+	}
+
+	parts := strings.Split(v, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid duration '%s'", v)
+	}
+
+	hours, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s'", v)
+	}
+	minutes, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil || minutes > 59 {
+		return 0, fmt.Errorf("invalid duration '%s'", v)
+	}
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil || seconds < 0 || seconds >= 60 || strings.ContainsAny(parts[2], "eE+-") {
+		return 0, fmt.Errorf("invalid duration '%s'", v)
+	}
+
+	d := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+	return d + time.Duration(seconds*float64(time.Second)+0.5), nil
+}
+
+// TimeValue returns Value() parsed with time.Parse in the given layout, ignoring surrounding whitespace.
+func (t Tag) TimeValue(layout string) (time.Time, error) {
+	v, err := t.Value()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(layout, strings.TrimSpace(v))
+}
+
+// SetValue replaces all Value, CDATA, EntityRef and Whitespace elements of the Tag with a single Value, placed where
+// the first of them was or appended if there were none. Tags, Comments and processing instructions are kept.
+func (t *Tag) SetValue(s string) *Tag {
+	var elements []Element
+	placed := false
+
+	for _, v := range t.elements {
+		switch v.(type) {
+		case *Value, *CDATA, *EntityRef, *Whitespace:
+			if !placed {
+				elements = append(elements, NewValue(s))
+				placed = true
+			}
+		default:
+			elements = append(elements, v)
+		}
+	}
+
+	if !placed {
+		elements = append(elements, NewValue(s))
+	}
+	t.elements = elements
+	return t
+}
+
+// SetIntValue is a wrapper for SetValue, writing i in base 10.
+func (t *Tag) SetIntValue(i int) *Tag {
+	return t.SetValue(strconv.Itoa(i))
+}
+
+// SetFloatValue is a wrapper for SetValue, writing f in the shortest representation that parses back to f.
+func (t *Tag) SetFloatValue(f float64) *Tag {
+	return t.SetValue(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// SetBoolValue is a wrapper for SetValue, writing 'true' or 'false'.
+func (t *Tag) SetBoolValue(b bool) *Tag {
+	return t.SetValue(strconv.FormatBool(b))
+}
+
+// SetDurationValue is a wrapper for SetValue, writing d in the format of time.Duration.String.
+func (t *Tag) SetDurationValue(d time.Duration) *Tag {
+	return t.SetValue(d.String())
+}
+
+// SetTimeValue is a wrapper for SetValue, writing tm formatted with the given layout.
+func (t *Tag) SetTimeValue(tm time.Time, layout string) *Tag {
+	return t.SetValue(tm.Format(layout))
+}
+
+// parseBool parses 'true', 'false', '1' or '0' surrounded by optional whitespace
+func parseBool(s string) (bool, bool) {
+	switch strings.TrimSpace(s) {
+	case "true", "1":
+		return true, true
+	case "false", "0":
+		return false, true
+	}
+	return false, false
 }
 
 // String returns a string representation of the entire Tag and its inner contents. No error
 // checking is done during String(), allowing for invalid XML to be produced.
 func (t Tag) String() string {
-	// TODO: method for indenting could be cleaner
-	var tagIndent string
-	var innerIndent string
-	var trailingInnerIndent string
-	var tagPrefix string
-	var attr string
+	var b strings.Builder
+	t.writeTo(&b)
+	return b.String()
+}
 
-	for i := 0; i < len(t.parents); i++ {
-		tagIndent = tagIndent + t.formatIndent
-	}
+// WriteTo implements the io.WriterTo interface. WriteTo writes the same output as String() to w through a buffer,
+// without building the whole Tag in memory.
+func (t *Tag) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	t.writeTo(bw)
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// writeTo writes the Tag and its inner contents to w
+func (t *Tag) writeTo(w xmlWriter) {
+	indented := t.formatPrefix != "" || t.formatIndent != ""
 
-	if (t.formatPrefix != "" || t.formatIndent != "") && len(t.Tags()) > 0 {
-		innerIndent = "\n"
-		trailingInnerIndent = innerIndent + t.formatPrefix + tagIndent
+	w.WriteString(t.lineIndent(t.formatDepth))
+	w.WriteByte('<')
+	t.writeName(w)
+	for _, v := range t.Attributes {
+		w.WriteByte(' ')
+		w.WriteString(v.String())
 	}
 
-	if t.Prefix != "" {
-		tagPrefix = fmt.Sprintf("%s:", t.Prefix)
+	if !t.hasContent() {
+		w.WriteString("/>")
+		return
 	}
+	w.WriteByte('>')
 
-	if len(t.Attributes) > 0 {
-		var s []string
-		for _, v := range t.Attributes {
-			s = append(s, v.String())
+	if !indented || t.isMixed() {
+		// mixed content and unformatted tags are written inline
+		for _, e := range t.elements {
+			writeElement(w, e)
+		}
+	} else {
+		// every child is written on its own line, Tags take care of their own indentation
+		for _, e := range t.elements {
+			w.WriteByte('\n')
+			if _, ok := e.(*Tag); !ok {
+				w.WriteString(t.lineIndent(t.formatDepth + 1))
+			}
+			writeElement(w, e)
 		}
-		attr = " " + strings.Join(s, " ")
+		w.WriteByte('\n')
+		w.WriteString(t.lineIndent(t.formatDepth))
 	}
 
-	v := t.innerValue()
-	if v == "" {
-		return fmt.Sprintf("%s%s<%s%s%s/>", t.formatPrefix, tagIndent, tagPrefix, t.Name, attr)
+	w.WriteString("</")
+	t.writeName(w)
+	w.WriteByte('>')
+}
+
+// qualifiedName returns the optionally prefixed name of the Tag (eg. 'prefix:name')
+func (t Tag) qualifiedName() string {
+	if t.Prefix != "" {
+		return t.Prefix + ":" + t.Name
+	}
+	return t.Name
+}
+
+// writeName writes the optionally prefixed name of the Tag to w
+func (t *Tag) writeName(w xmlWriter) {
+	if t.Prefix != "" {
+		w.WriteString(t.Prefix)
+		w.WriteByte(':')
 	}
-	return fmt.Sprintf("%s%s<%s%s%s>%s%s%s</%s%s>", t.formatPrefix, tagIndent, tagPrefix, t.Name, attr, innerIndent, v, trailingInnerIndent, tagPrefix, t.Name)
+	w.WriteString(t.Name)
 }
 
-// Marshal is a wrapper for String() but returns a []byte, error to conform to the normal Marshaler interface.
+// Marshal is a wrapper for WriteTo but returns a []byte, error to conform to the normal Marshaler interface.
 func (t *Tag) Marshal() ([]byte, error) {
-	t.setIndent("", "")
-	return []byte(t.String()), nil
+	t.setIndent("", "", 0)
+	var b bytes.Buffer
+	if _, err := t.WriteTo(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
 }
 
-/*
-TODO: Removed from scope of v0.1
+// MarshalIndent works like Marshal, but each child Tag and Comment begins on a new line starting with prefix
+// followed by one or more copies of indent according to the nesting depth. Tags with mixed content are written
+// as is.
+func (t *Tag) MarshalIndent(prefix, indent string) ([]byte, error) {
+	t.setIndent(prefix, indent, 0)
+	defer t.setIndent("", "", 0)
 
-// UnmarshalStrict is a custom XML unmarshaller that behaves much like xml.Unmarshal with a few enahncements, including the return of a UnmarshalResult.
-func (t Tag) UnmarshalStrict(v interface{}) (UnmarshalResult, error) {
-	return UnmarshalResult{}, nil
+	var b bytes.Buffer
+	if _, err := t.WriteTo(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
 }
 
-// UnmarshalResult is returned during UnmarshalStrict(), containing a slice of XPath that were and were not successfully Unmarshaled.
-type UnmarshalResult struct {
-	Used   []XPath
-	Unused []XPath
+// Errors recursively checks the Tag for anything that makes the XML document invalid and returns a slice of error.
+// Each error is a *ValidationError carrying the XPath of the offending Tag.
+func (t *Tag) Errors() []error {
+	return t.errors(t.lineage())
 }
 
-// Errors recursively checks the Tag for anything that makes the XML document invalid and returns a slice of error.
-func (t Tag) Errors() []error {
+// errors checks the Tag with the given ancestors, which are passed down rather than read from parents so that
+// namespaces and paths are correct for trees that were not built by NewDocumentFromReader.
+func (t *Tag) errors(ancestors []*Tag) []error {
 	var errs []error
 
-	// ensure a non Comment and Non Tag type do not coexist with a Tag type within the same Tag
-
-			// scan all non Tag and non CDATA elements to determine if they should be CDATA
-			for _, v := range t.elements {
-				switch k := v.(type) {
-				case *Tag:
-					// skip
-				case *CDATA:
-					// skip
-				default:
-					fmt.Println(reflect.TypeOf(v).String())
-					fmt.Println(v.String())
-					if NeedCDATA(k.String()) {
-						errs = append(errs, errors.New(t.XPath().String()+": should be CDATA"))
-					}
-				}
-			}
+	path := XPath{}
+	for _, v := range ancestors {
+		path = append(path, v.qualifiedName())
+	}
+	path = append(path, t.qualifiedName())
 
-		// ensure all CDATA elements do not contain ']]>'
-		for _, v := range t.elements {
-			switch k := v.(type) {
-			case *CDATA:
-				if strings.Contains(k.String(), "]]>") {
-					errs = append(errs, errors.New(t.XPath().String()+": contains invalid CDATA"))
-				}
-			}
+	invalid := func(format string, a ...interface{}) {
+		errs = append(errs, &ValidationError{Path: path, Msg: fmt.Sprintf(format, a...)})
+	}
+
+	// ensure the name and prefix are valid and the prefix has a namespace
+	if !isXMLName(t.Name) {
+		invalid("invalid name '%s'", t.Name)
+	}
+	if t.Prefix != "" {
+		if !isXMLName(t.Prefix) {
+			invalid("invalid prefix '%s'", t.Prefix)
+		} else if !prefixDeclared(t.Prefix, t, ancestors) {
+			invalid("prefix '%s' does not have a defined namespace", t.Prefix)
 		}
+	}
 
-		// ensure current prefix has a namespace
-		if t.Prefix != "" {
-			if _, err := t.GetNamespace(t.Prefix); err != nil {
-				errs = append(errs, errors.New(t.XPath().String()+": prefix '"+t.Prefix+"' does not have a defined namespace"))
-			}
+	// ensure attribute names are valid, prefixes have a namespace and no attribute is defined twice
+	seen := map[string]bool{}
+	for _, attr := range t.Attributes {
+		qualified := attr.Name
+		if attr.Prefix != "" {
+			qualified = attr.Prefix + ":" + attr.Name
 		}
 
-		// ensure all comments do not contain '--'
-		for _, v := range t.elements {
-			switch k := v.(type) {
-			case *Comment:
-				if strings.Contains(k.String(), "--") {
-					errs = append(errs, errors.New(t.XPath().String()+": contains invalid Comments"))
-				}
-			}
+		if !isXMLName(attr.Name) || attr.Prefix != "" && !isXMLName(attr.Prefix) {
+			invalid("invalid attribute name '%s'", qualified)
+		} else if attr.Prefix != "" && !attr.IsNamespace() && !prefixDeclared(attr.Prefix, t, ancestors) {
+			invalid("attribute prefix '%s' does not have a defined namespace", attr.Prefix)
 		}
 
-		// run on all child tags
-		for _, v := range t.Tags() {
-			errs = append(errs, v.Errors()...)
+		if seen[qualified] {
+			invalid("attribute '%s' defined more than once", qualified)
+		}
+		seen[qualified] = true
+	}
+
+	for _, v := range t.elements {
+		switch k := v.(type) {
+		case *Value:
+			// markup is escaped when written, only characters that XML does not allow can not be represented
+			if strings.IndexFunc(string(*k), func(r rune) bool { return !isXMLChar(r) }) >= 0 {
+				invalid("contains characters not allowed in XML")
+			}
+		case *CDATA:
+			// ensure all CDATA elements do not contain ']]>'
+			if strings.Contains(string(*k), "]]>") {
+				invalid("contains invalid CDATA")
+			}
+		case *Comment:
+			// ensure all comments do not contain '--' or end in '-'
+			if strings.Contains(string(*k), "--") || strings.HasSuffix(string(*k), "-") {
+				invalid("contains invalid Comments")
+			}
+		case *ProcInst:
+			if !k.valid() {
+				invalid("contains invalid processing instruction '%s'", k.Target)
+			}
+		case *Doctype:
+			invalid("contains a DOCTYPE")
 		}
+	}
+
+	// run on all child tags
+	children := append(append([]*Tag{}, ancestors...), t)
+	for _, v := range t.Tags() {
+		errs = append(errs, v.errors(children)...)
+	}
 
 	return errs
 }
-*/
+
+// prefixDeclared returns true if a namespace for prefix is declared on t or one of its ancestors. The 'xml' and
+// 'xmlns' prefixes are always declared.
+func prefixDeclared(prefix string, t *Tag, ancestors []*Tag) bool {
+	if prefix == "xml" || prefix == "xmlns" {
+		return true
+	}
+
+	for _, v := range append(append([]*Tag{}, ancestors...), t) {
+		for _, attr := range v.Attributes {
+			if attr.IsNamespace() && attr.declaredPrefix() == prefix {
+				return true
+			}
+		}
+	}
+	return false
+}
 
 // Search returns a new Search with the current Tag
 func (t *Tag) Search() Search {
@@ -424,3 +1289,32 @@ func NewTag(name string) *Tag {
 		Name: name,
 	}
 }
+
+// NewTagFromValue returns a pointer to a new Tag built from the XML encoding of v. v is encoded with encoding/xml, so
+// struct tags (attr, chardata, cdata, comment, innerxml, omitempty, namespaces) and the xml.Marshaler interfaces are
+// honored exactly as they are by xml.Marshal. An error is returned if v does not encode to exactly one element.
+func NewTagFromValue(v interface{}) (*Tag, error) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := NewDocumentFromReaderWithOptions(bytes.NewReader(b), ParseOptions{PreserveWhitespace: true, DecodeReferences: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []*Tag
+	for _, e := range doc.elements {
+		if t, ok := e.(*Tag); ok {
+			tags = append(tags, t)
+		}
+	}
+
+	if len(tags) != 1 {
+		return nil, fmt.Errorf("value encoded to %d elements, expected 1", len(tags))
+	}
+
+	tags[0].document = nil
+	return tags[0], nil
+}
@@ -0,0 +1,451 @@
+package simplexml
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+
+	"strings"
+	"time"
+)
+
+func TestErrors(t *testing.T) {
+	Convey("Given a tree built from scratch with a namespace declared on the root", t, func() {
+		root := NewTag("root").AddNamespace("urn", "http://foo")
+		foo := NewTag("foo")
+		foo.Prefix = "urn"
+		root.AddAfter(foo, nil)
+
+		Convey("Errors() should be empty", func() {
+			So(root.Errors(), ShouldBeEmpty)
+		})
+
+		Convey("Given a child with an undeclared prefix, a bad CDATA and a bad comment", func() {
+			bar := NewTag("bar")
+			bar.Prefix = "undeclared"
+			bar.AddAfter(NewCDATA("foo]]>bar"), nil)
+			bar.AddAfter(NewComment("foo--bar"), nil)
+			foo.AddAfter(bar, nil)
+
+			Convey("Errors() should report each problem with the Tag's XPath", func() {
+				errs := root.Errors()
+				So(len(errs), ShouldEqual, 3)
+				So(errs[0].Error(), ShouldEqual, "/root/urn:foo/undeclared:bar: prefix 'undeclared' does not have a defined namespace")
+				So(errs[1].Error(), ShouldEqual, "/root/urn:foo/undeclared:bar: contains invalid CDATA")
+				So(errs[2].Error(), ShouldEqual, "/root/urn:foo/undeclared:bar: contains invalid Comments")
+				So(errs[0], ShouldHaveSameTypeAs, &ValidationError{})
+			})
+		})
+
+		Convey("Given invalid names, duplicate attributes and a control character next to a Tag", func() {
+			bad := NewTag("1bad")
+			bad.AddAttribute("a", "1", "").AddAttribute("a", "2", "").AddAttribute("b c", "3", "")
+			foo.AddAfter(bad, nil)
+			foo.AddAfter(NewValue("bell\x07"), nil)
+
+			Convey("Errors() should report each problem", func() {
+				errs := root.Errors()
+				So(len(errs), ShouldEqual, 4)
+				So(errs[0].Error(), ShouldEqual, "/root/urn:foo: contains characters not allowed in XML")
+				So(errs[1].Error(), ShouldEqual, "/root/urn:foo/1bad: invalid name '1bad'")
+				So(errs[2].Error(), ShouldEqual, "/root/urn:foo/1bad: attribute 'a' defined more than once")
+				So(errs[3].Error(), ShouldEqual, "/root/urn:foo/1bad: invalid attribute name 'b c'")
+			})
+		})
+	})
+}
+
+type tagFromValueItem struct {
+	XMLName struct{} `xml:"item"`
+	ID      int      `xml:"id,attr"`
+	Type    string   `xml:"type,attr,omitempty"`
+	Note    string   `xml:",comment"`
+	Title   string   `xml:"title"`
+	Media   []string `xml:"media>url"`
+	Empty   string   `xml:"empty,omitempty"`
+}
+
+func TestNewTagFromValue(t *testing.T) {
+	Convey("Given a Tag from a struct with encoding/xml struct tags", t, func() {
+		tag, err := NewTagFromValue(tagFromValueItem{ID: 3, Note: " note ", Title: "a & b", Media: []string{"a.mp4", "b.mp4"}})
+		So(err, ShouldBeNil)
+
+		Convey("The Tag should match the struct", func() {
+			So(tag.Name, ShouldEqual, "item")
+			So(len(tag.Attributes), ShouldEqual, 1)
+			So(tag.Attributes[0].Value, ShouldEqual, "3")
+
+			title := tag.Search().ByName("title").One()
+			So(title, ShouldNotBeNil)
+			v, err := title.Value()
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, "a & b")
+
+			So(len(tag.Search().ByName("media").ByName("url")), ShouldEqual, 2)
+			So(tag.Search().ByName("empty").One(), ShouldBeNil)
+		})
+
+		Convey("The Tag should be editable and marshal back to XML", func() {
+			tag.AddAfter(NewTag("added"), nil)
+			b, err := tag.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `<item id="3"><!-- note --><title>a &amp; b</title><media><url>a.mp4</url><url>b.mp4</url></media><added/></item>`)
+		})
+	})
+
+	Convey("Given a value that does not encode to one element", t, func() {
+		_, err := NewTagFromValue([]string{"a", "b"})
+
+		Convey("An error should be returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestParent(t *testing.T) {
+	Convey("Given a Document built from NewTag and AddAfter", t, func() {
+		root := NewTag("root")
+		d := NewDocument(root)
+		foo := NewTag("foo")
+		bar := NewTag("bar")
+		root.AddAfter(foo, nil)
+		foo.AddAfter(bar, nil)
+
+		Convey("Parent, Ancestors and Document should reflect the tree", func() {
+			So(bar.Parent(), ShouldEqual, foo)
+			So(bar.Ancestors(), ShouldResemble, []*Tag{foo, root})
+			So(bar.Document(), ShouldEqual, d)
+			So(bar.XPath().String(), ShouldEqual, "/root/foo/bar")
+			So(root.Parent(), ShouldBeNil)
+		})
+
+		Convey("Namespaces should be available from parents", func() {
+			root.AddNamespace("a", "urn:a")
+			ns, err := bar.GetNamespace("a")
+			So(err, ShouldBeNil)
+			So(ns, ShouldEqual, "urn:a")
+		})
+
+		Convey("Adding bar to root should move it out of foo", func() {
+			So(root.AddBefore(bar, foo), ShouldBeNil)
+			So(len(foo.Tags()), ShouldEqual, 0)
+			So(root.Tags(), ShouldResemble, []*Tag{bar, foo})
+			So(bar.Parent(), ShouldEqual, root)
+		})
+
+		Convey("Adding foo to its own descendant should return an error and leave the tree unchanged", func() {
+			So(bar.AddAfter(foo, nil), ShouldNotBeNil)
+			So(foo.AddAfter(foo, nil), ShouldNotBeNil)
+			So(foo.Parent(), ShouldEqual, root)
+			So(len(bar.Tags()), ShouldEqual, 0)
+		})
+
+		Convey("Remove should detach the Tag", func() {
+			So(root.Remove(foo), ShouldBeNil)
+			So(foo.Parent(), ShouldBeNil)
+			So(bar.Document(), ShouldBeNil)
+			So(bar.XPath().String(), ShouldEqual, "/foo/bar")
+		})
+	})
+}
+
+func TestClone(t *testing.T) {
+	Convey("Given a Tag parsed from a document", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(`<?xml version="1.0"?><VAST xmlns:a="urn:a"><Creative a:id="1"><Linear>text<!-- c --></Linear></Creative></VAST>`))
+		So(err, ShouldBeNil)
+		creative := d.Root().Search().ByName("Creative").One()
+		c := creative.Clone()
+
+		Convey("The clone should be detached and marshal the same", func() {
+			So(c.Parent(), ShouldBeNil)
+			So(c.Document(), ShouldBeNil)
+			So(c.Tags()[0].Parent(), ShouldEqual, c)
+			So(c.String(), ShouldEqual, `<Creative a:id="1" xmlns:a="urn:a"><Linear>text<!-- c --></Linear></Creative>`)
+		})
+
+		Convey("The clone should declare the namespaces it uses from its ancestors", func() {
+			So(c.Errors(), ShouldBeEmpty)
+			ns, err := c.GetNamespace("a")
+			So(err, ShouldBeNil)
+			So(ns, ShouldEqual, "urn:a")
+
+			x, err := NewDocumentFromReader(strings.NewReader(`<r xmlns="urn:d" xmlns:a="urn:a" xmlns:b="urn:b"><a:x><y b:id="1"/><a:z xmlns:a="urn:other"/></a:x></r>`))
+			So(err, ShouldBeNil)
+			xc := x.Root().Tags()[0].Clone()
+			So(xc.Errors(), ShouldBeEmpty)
+			So(xc.String(), ShouldEqual, `<a:x xmlns:a="urn:a" xmlns="urn:d" xmlns:b="urn:b"><y b:id="1"/><a:z xmlns:a="urn:other"/></a:x>`)
+			uri, err := xc.Tags()[0].NamespaceURI()
+			So(err, ShouldBeNil)
+			So(uri, ShouldEqual, "urn:d")
+		})
+
+		Convey("Changing the clone should not change the original", func() {
+			c.Attributes[0].Value = "2"
+			c.Tags()[0].Name = "NonLinear"
+			*c.Tags()[0].elements[0].(*Value) = "changed"
+			So(creative.String(), ShouldEqual, `<Creative a:id="1"><Linear>text<!-- c --></Linear></Creative>`)
+		})
+
+		Convey("A clone added to the root should resolve namespaces through its new parent", func() {
+			So(d.Root().AddAfter(c, nil), ShouldBeNil)
+			So(len(d.Root().Tags()), ShouldEqual, 2)
+			ns, err := c.GetNamespace("a")
+			So(err, ShouldBeNil)
+			So(ns, ShouldEqual, "urn:a")
+		})
+
+		Convey("Document.Clone should copy the Declaration and the whole tree", func() {
+			dc := d.Clone()
+			So(dc.Declaration, ShouldResemble, d.Declaration)
+			So(dc.Declaration, ShouldNotPointTo, d.Declaration)
+			So(dc.Root(), ShouldNotPointTo, d.Root())
+			So(dc.Root().Document(), ShouldEqual, dc)
+
+			a, _ := d.Marshal()
+			b, _ := dc.Marshal()
+			So(string(b), ShouldEqual, string(a))
+		})
+	})
+}
+
+func TestNavigation(t *testing.T) {
+	Convey("Given a Tag with mixed child Elements", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(`<!-- top --><root>a<b/><!-- c --><d/>e</root>`))
+		So(err, ShouldBeNil)
+		root := d.Root()
+		b := root.ChildTagAt(0)
+		dt := root.ChildTagAt(1)
+
+		Convey("Child accessors should index all Elements", func() {
+			So(root.FirstChild(), ShouldResemble, NewValue("a"))
+			So(root.LastChild(), ShouldResemble, NewValue("e"))
+			So(root.ChildAt(1), ShouldEqual, b)
+			So(root.ChildAt(5), ShouldBeNil)
+			So(root.IndexOf(dt), ShouldEqual, 3)
+			So(root.IndexOf(NewTag("d")), ShouldEqual, -1)
+		})
+
+		Convey("Siblings should be relative to the given Element", func() {
+			So(root.NextSibling(b), ShouldResemble, NewComment(" c "))
+			So(root.PreviousSibling(b), ShouldResemble, NewValue("a"))
+			So(root.NextSibling(root.LastChild()), ShouldBeNil)
+			So(b.Parent().NextSiblingTag(b), ShouldEqual, dt)
+			So(root.PreviousSiblingTag(dt), ShouldEqual, b)
+			So(root.PreviousSiblingTag(b), ShouldBeNil)
+		})
+
+		Convey("Nil and non-pointer Elements should not be found", func() {
+			So(root.IndexOf(nil), ShouldEqual, -1)
+			So(root.IndexOf(Value("a")), ShouldEqual, -1)
+			So(root.NextSibling(root.ChildAt(99)), ShouldBeNil)
+			So(root.PreviousSibling(nil), ShouldBeNil)
+			So(root.NextSiblingTag(root.ChildTagAt(99)), ShouldBeNil)
+			So(root.PreviousSiblingTag(nil), ShouldBeNil)
+			So(d.IndexOf(nil), ShouldEqual, -1)
+			So(d.NextSibling(d.ChildAt(99)), ShouldBeNil)
+			So(d.PreviousSiblingTag(nil), ShouldBeNil)
+			So(d.NextSiblingTag(Value("a")), ShouldBeNil)
+		})
+
+		Convey("Tag only accessors should skip other Elements", func() {
+			So(root.FirstChildTag(), ShouldEqual, b)
+			So(root.LastChildTag(), ShouldEqual, dt)
+			So(root.IndexOfTag(dt), ShouldEqual, 1)
+			So(root.ChildTagAt(2), ShouldBeNil)
+		})
+
+		Convey("Document should navigate its top level Elements", func() {
+			So(d.FirstChild(), ShouldResemble, NewComment(" top "))
+			So(d.FirstChildTag(), ShouldEqual, root)
+			So(d.PreviousSibling(root), ShouldEqual, d.FirstChild())
+			So(d.IndexOfTag(root), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestEditing(t *testing.T) {
+	Convey("Given a feed with two items", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(`<feed><item><title>a</title><link>x</link></item><item><title>b</title></item></feed>`))
+		So(err, ShouldBeNil)
+		feed := d.Root()
+		first, second := feed.ChildTagAt(0), feed.ChildTagAt(1)
+		title := first.ChildTagAt(0)
+
+		Convey("ReplaceWith should swap a child and detach the old one", func() {
+			link := NewTag("link")
+			So(first.ReplaceWith(title, link), ShouldBeNil)
+			So(feed.String(), ShouldEqual, `<feed><item><link/><link>x</link></item><item><title>b</title></item></feed>`)
+			So(title.Parent(), ShouldBeNil)
+			So(link.Parent(), ShouldEqual, first)
+
+			So(first.ReplaceWith(title, link), ShouldNotBeNil)
+			So(first.ReplaceWith(link, feed), ShouldNotBeNil)
+		})
+
+		Convey("Wrap should place the element inside the wrapper", func() {
+			entry := NewTag("entry")
+			So(feed.Wrap(second, entry), ShouldBeNil)
+			So(feed.String(), ShouldEqual, `<feed><item><title>a</title><link>x</link></item><entry><item><title>b</title></item></entry></feed>`)
+			So(second.Ancestors(), ShouldResemble, []*Tag{entry, feed})
+
+			So(feed.Wrap(first, title), ShouldNotBeNil)
+			So(feed.Wrap(first, feed), ShouldNotBeNil)
+		})
+
+		Convey("Unwrap should splice the children into the parent", func() {
+			So(feed.Unwrap(first), ShouldBeNil)
+			So(feed.String(), ShouldEqual, `<feed><title>a</title><link>x</link><item><title>b</title></item></feed>`)
+			So(title.Parent(), ShouldEqual, feed)
+			So(first.Parent(), ShouldBeNil)
+			So(feed.Unwrap(first), ShouldNotBeNil)
+		})
+
+		Convey("MoveBefore and MoveAfter should move Tags across parents and Elements within the Tag", func() {
+			So(second.MoveBefore(title, nil), ShouldBeNil)
+			So(feed.String(), ShouldEqual, `<feed><item><link>x</link></item><item><title>a</title><title>b</title></item></feed>`)
+
+			So(feed.MoveAfter(first, second), ShouldBeNil)
+			So(feed.Tags(), ShouldResemble, []*Tag{second, first})
+
+			v := second.ChildTagAt(1).FirstChild()
+			So(second.MoveAfter(v, nil), ShouldNotBeNil)
+		})
+
+		Convey("ReplaceChildren should replace every element", func() {
+			So(feed.ReplaceChildren(second, NewComment(" only "), title), ShouldBeNil)
+			So(feed.String(), ShouldEqual, `<feed><item><title>b</title></item><!-- only --><title>a</title></feed>`)
+			So(first.Parent(), ShouldBeNil)
+			So(title.Parent(), ShouldEqual, feed)
+			So(len(first.Tags()), ShouldEqual, 1)
+
+			So(title.ReplaceChildren(feed), ShouldNotBeNil)
+			So(title.String(), ShouldEqual, "<title>a</title>")
+		})
+	})
+}
+
+func TestAttributes(t *testing.T) {
+	Convey("Given a Tag with plain, prefixed and typed Attributes", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(`<ad xmlns:v="urn:vast" id="7" v:skip="1" width=" 300 " ratio="1.5"/>`))
+		So(err, ShouldBeNil)
+		ad := d.Root()
+
+		Convey("Attr and HasAttr should find Attributes by their name as written", func() {
+			So(ad.Attr("id").Value, ShouldEqual, "7")
+			So(ad.Attr("v:skip").Value, ShouldEqual, "1")
+			So(ad.Attr("skip"), ShouldBeNil)
+			So(ad.HasAttr("width"), ShouldBeTrue)
+			So(ad.HasAttr("height"), ShouldBeFalse)
+		})
+
+		Convey("AttrNS should resolve the prefix of an Attribute", func() {
+			So(ad.AttrNS("urn:vast", "skip"), ShouldEqual, ad.Attr("v:skip"))
+			So(ad.AttrNS("", "id"), ShouldEqual, ad.Attr("id"))
+			So(ad.AttrNS("urn:other", "skip"), ShouldBeNil)
+		})
+
+		Convey("AttrNS should resolve the predefined xml prefix", func() {
+			ad.SetAttr("xml:lang", "en")
+			So(ad.AttrNS("http://www.w3.org/XML/1998/namespace", "lang"), ShouldEqual, ad.Attr("xml:lang"))
+			So(ad.AttrNS("", "lang"), ShouldBeNil)
+		})
+
+		Convey("Typed getters should parse the value", func() {
+			w, err := ad.AttrInt("width")
+			So(err, ShouldBeNil)
+			So(w, ShouldEqual, 300)
+
+			r, err := ad.AttrFloat("ratio")
+			So(err, ShouldBeNil)
+			So(r, ShouldEqual, 1.5)
+
+			b, err := ad.AttrBool("v:skip")
+			So(err, ShouldBeNil)
+			So(b, ShouldBeTrue)
+
+			_, err = ad.AttrInt("height")
+			So(err, ShouldNotBeNil)
+			_, err = ad.AttrBool("ratio")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("SetAttr should update or append and RemoveAttr should delete", func() {
+			ad.SetAttr("id", "8").SetAttr("xml:lang", "en")
+			So(ad.RemoveAttr("width"), ShouldBeTrue)
+			So(ad.RemoveAttr("width"), ShouldBeFalse)
+			So(ad.String(), ShouldEqual, `<ad xmlns:v="urn:vast" id="8" v:skip="1" ratio="1.5" xml:lang="en"/>`)
+		})
+	})
+
+	Convey("Given Attribute values with markup, quotes and whitespace", t, func() {
+		tag := NewTag("a").SetAttr("title", "\"Tom\" & <Jerry>\n")
+		tag.Attributes = append(tag.Attributes, &Attribute{Name: "alt", Value: `it's "here"`, Quote: '\''})
+
+		Convey("String should escape them for the quote in use", func() {
+			So(tag.String(), ShouldEqual, `<a title="&quot;Tom&quot; &amp; &lt;Jerry>&#xA;" alt='it&apos;s "here"'/>`)
+			So(tag.Errors(), ShouldBeEmpty)
+		})
+
+		Convey("The escaped values should parse back to the same values", func() {
+			d, err := NewDocumentFromReader(strings.NewReader(tag.String()))
+			So(err, ShouldBeNil)
+			So(d.Root().Attr("title").Value, ShouldEqual, "\"Tom\" & <Jerry>\n")
+			So(d.Root().Attr("alt").Value, ShouldEqual, `it's "here"`)
+		})
+	})
+}
+
+func TestTypedValues(t *testing.T) {
+	Convey("Given a VAST Linear Tag", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(`<Linear><Duration> 00:01:30.500 </Duration><Skip>1</Skip><Count>12</Count><Ratio>0.75</Ratio><Date>2020-01-02</Date><Title>A &amp; <![CDATA[<B>]]><!-- c --></Title><Note>x<b>y</b>z</Note></Linear>`))
+		So(err, ShouldBeNil)
+		linear := d.Root()
+		child := func(name string) *Tag { return linear.Search().ByName(name).One() }
+
+		Convey("Typed getters should parse the value", func() {
+			dur, err := child("Duration").DurationValue()
+			So(err, ShouldBeNil)
+			So(dur, ShouldEqual, 90*time.Second+500*time.Millisecond)
+
+			b, err := child("Skip").BoolValue()
+			So(err, ShouldBeNil)
+			So(b, ShouldBeTrue)
+
+			i, err := child("Count").IntValue()
+			So(err, ShouldBeNil)
+			So(i, ShouldEqual, 12)
+
+			f, err := child("Ratio").FloatValue()
+			So(err, ShouldBeNil)
+			So(f, ShouldEqual, 0.75)
+
+			tm, err := child("Date").TimeValue("2006-01-02")
+			So(err, ShouldBeNil)
+			So(tm.Day(), ShouldEqual, 2)
+
+			_, err = child("Title").IntValue()
+			So(err, ShouldNotBeNil)
+			_, err = child("Note").BoolValue()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("TextContent should join all descendant text", func() {
+			So(child("Title").TextContent(), ShouldEqual, "A & <B>")
+			So(child("Note").TextContent(), ShouldEqual, "xyz")
+		})
+
+		Convey("SetValue should replace the text and keep other elements", func() {
+			child("Title").SetValue("C")
+			So(child("Title").String(), ShouldEqual, "<Title>C<!-- c --></Title>")
+
+			child("Duration").SetDurationValue(2 * time.Second)
+			dur, err := child("Duration").DurationValue()
+			So(err, ShouldBeNil)
+			So(dur, ShouldEqual, 2*time.Second)
+
+			So(NewTag("n").SetIntValue(3).String(), ShouldEqual, "<n>3</n>")
+			So(NewTag("n").SetFloatValue(0.1).String(), ShouldEqual, "<n>0.1</n>")
+			So(NewTag("n").SetBoolValue(false).String(), ShouldEqual, "<n>false</n>")
+			So(NewTag("n").SetTimeValue(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), "2006-01-02").String(), ShouldEqual, "<n>2020-01-02</n>")
+		})
+	})
+}
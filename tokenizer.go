@@ -0,0 +1,609 @@
+package simplexml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tokenKind is the type of a token read by the tokenizer
+type tokenKind int
+
+const (
+	startToken tokenKind = iota
+	endToken
+	textToken
+	cdataToken
+	commentToken
+	procInstToken
+	directiveToken
+)
+
+// token is a single piece of markup or text. Only the fields relevant to its kind are set.
+type token struct {
+	kind tokenKind
+
+	// prefix and name are set for start and end tokens, name holds the target of a processing instruction
+	prefix string
+	name   string
+
+	// attrs are the attributes of a start token, selfClosing is true for '<name/>'
+	attrs       []*Attribute
+	selfClosing bool
+
+	// text is the undecoded contents of a text, CDATA, comment, processing instruction or directive token
+	text string
+
+	// raw is the exact source of the token
+	raw string
+
+	// offset is the byte offset of the token in the input
+	offset int
+}
+
+// tokenizer is a non validating XML tokenizer that keeps the source form of the document: text is not decoded,
+// prefixes are not translated and the quote used around each attribute value is recorded. Matching of start and end
+// elements is left to the caller.
+type tokenizer struct {
+	src []byte
+	pos int
+}
+
+// newTokenizer returns a tokenizer reading from src
+func newTokenizer(src []byte) *tokenizer {
+	return &tokenizer{src: src}
+}
+
+// tokenError is returned by the tokenizer for malformed markup
+type tokenError struct {
+	offset int
+	msg    string
+}
+
+func (e *tokenError) Error() string {
+	return e.msg
+}
+
+func (z *tokenizer) errorf(offset int, format string, a ...interface{}) error {
+	return &tokenError{offset: offset, msg: fmt.Sprintf(format, a...)}
+}
+
+// position returns the 1 based line and column of a byte offset
+func (z *tokenizer) position(offset int) (int, int) {
+	if offset > len(z.src) {
+		offset = len(z.src)
+	}
+	before := z.src[:offset]
+	line := bytes.Count(before, []byte{'\n'}) + 1
+	column := utf8.RuneCount(before[bytes.LastIndexByte(before, '\n')+1:]) + 1
+	return line, column
+}
+
+// next returns the next token, or false at the end of the input
+func (z *tokenizer) next() (token, bool, error) {
+	if z.pos >= len(z.src) {
+		return token{}, false, nil
+	}
+
+	start := z.pos
+	if z.src[start] != '<' {
+		end := bytes.IndexByte(z.src[start:], '<')
+		if end < 0 {
+			end = len(z.src) - start
+		}
+		z.pos = start + end
+		text := string(z.src[start:z.pos])
+		return token{kind: textToken, text: text, raw: text, offset: start}, true, nil
+	}
+
+	var t token
+	var err error
+
+	rest := z.src[start:]
+	switch {
+	case bytes.HasPrefix(rest, []byte("<!--")):
+		t, err = z.delimited(commentToken, "<!--", "-->")
+	case bytes.HasPrefix(rest, []byte("<![CDATA[")):
+		t, err = z.delimited(cdataToken, "<![CDATA[", "]]>")
+	case bytes.HasPrefix(rest, []byte("<?")):
+		t, err = z.procInst()
+	case bytes.HasPrefix(rest, []byte("<!")):
+		t, err = z.directive()
+	case bytes.HasPrefix(rest, []byte("</")):
+		t, err = z.endElement()
+	default:
+		t, err = z.startElement()
+	}
+
+	if err != nil {
+		return token{}, false, err
+	}
+
+	t.offset = start
+	t.raw = string(z.src[start:z.pos])
+	return t, true, nil
+}
+
+// delimited reads a token that runs from open to the first close, such as a comment or CDATA section
+func (z *tokenizer) delimited(kind tokenKind, open, close string) (token, error) {
+	start := z.pos
+	end := bytes.Index(z.src[start+len(open):], []byte(close))
+	if end < 0 {
+		return token{}, z.errorf(start, "unexpected EOF in %s", strings.TrimLeft(open, "<!?["))
+	}
+
+	text := string(z.src[start+len(open) : start+len(open)+end])
+	z.pos = start + len(open) + end + len(close)
+
+	if kind == commentToken && strings.Contains(text, "--") {
+		return token{}, z.errorf(start, "invalid sequence \"--\" not allowed in comments")
+	}
+	return token{kind: kind, text: text}, nil
+}
+
+// procInst reads a '<?target data?>' processing instruction
+func (z *tokenizer) procInst() (token, error) {
+	start := z.pos
+	end := bytes.Index(z.src[start:], []byte("?>"))
+	if end < 0 {
+		return token{}, z.errorf(start, "unexpected EOF in processing instruction")
+	}
+	z.pos = start + end + 2
+
+	body := string(z.src[start+2 : start+end])
+	target := body
+	data := ""
+	if i := strings.IndexAny(body, " \t\r\n"); i >= 0 {
+		target = body[:i]
+		data = strings.TrimLeft(body[i:], " \t\r\n")
+	}
+
+	if !isXMLName(strings.Replace(target, ":", "_", -1)) {
+		return token{}, z.errorf(start, "invalid processing instruction target '%s'", target)
+	}
+	return token{kind: procInstToken, name: target, text: data}, nil
+}
+
+// directive reads a '<!...>' directive such as a DOCTYPE, skipping over quoted strings, comments and an internal
+// subset in square brackets
+func (z *tokenizer) directive() (token, error) {
+	start := z.pos
+	i := start + 2
+	depth := 0
+
+	for i < len(z.src) {
+		switch c := z.src[i]; {
+		case c == '"' || c == '\'':
+			end := bytes.IndexByte(z.src[i+1:], c)
+			if end < 0 {
+				return token{}, z.errorf(start, "unexpected EOF in directive")
+			}
+			i += end + 2
+			continue
+		case bytes.HasPrefix(z.src[i:], []byte("<!--")):
+			end := bytes.Index(z.src[i+4:], []byte("-->"))
+			if end < 0 {
+				return token{}, z.errorf(start, "unexpected EOF in directive")
+			}
+			i += end + 7
+			continue
+		case c == '<':
+			depth++
+		case c == '>':
+			if depth == 0 {
+				z.pos = i + 1
+				return token{kind: directiveToken, text: string(z.src[start+2 : i])}, nil
+			}
+			depth--
+		}
+		i++
+	}
+
+	return token{}, z.errorf(start, "unexpected EOF in directive")
+}
+
+// parseDeclaration parses the data of an '<?xml ...?>' declaration, the version, encoding and standalone pseudo
+// attributes must appear in that order
+func parseDeclaration(s string) (*Declaration, error) {
+	d := &Declaration{}
+	names := []string{"version", "encoding", "standalone"}
+	values := []*string{&d.Version, &d.Encoding, &d.Standalone}
+
+	next := 0
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimLeft(s, " \t\r\n") {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("invalid XML declaration '%s'", s)
+		}
+		name := strings.TrimRight(s[:eq], " \t\r\n")
+		s = strings.TrimLeft(s[eq+1:], " \t\r\n")
+
+		if s == "" || s[0] != '"' && s[0] != '\'' {
+			return nil, fmt.Errorf("expected a quoted value for '%s' in XML declaration", name)
+		}
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated value for '%s' in XML declaration", name)
+		}
+		value := s[1 : end+1]
+		s = s[end+2:]
+
+		for next < len(names) && names[next] != name {
+			next++
+		}
+		if next == len(names) {
+			return nil, fmt.Errorf("unexpected '%s' in XML declaration", name)
+		}
+		*values[next] = value
+		next++
+	}
+
+	if d.Version == "" {
+		return nil, errors.New("missing version in XML declaration")
+	}
+	if errs := d.Errors(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return d, nil
+}
+
+// parseDoctype parses the text of a '<!DOCTYPE ...>' directive, without the '<!' and '>' markup
+func parseDoctype(s string) (*Doctype, error) {
+	if !strings.HasPrefix(s, "DOCTYPE") {
+		return nil, errors.New("expected DOCTYPE")
+	}
+	s = s[len("DOCTYPE"):]
+
+	// space returns s without leading whitespace and false if there was none
+	space := func() bool {
+		n := len(s)
+		s = strings.TrimLeft(s, " \t\r\n")
+		return len(s) < n
+	}
+
+	// literal reads a quoted string
+	literal := func() (string, error) {
+		if !space() || s == "" || s[0] != '"' && s[0] != '\'' {
+			return "", errors.New("expected a quoted literal in DOCTYPE")
+		}
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return "", errors.New("unterminated literal in DOCTYPE")
+		}
+		v := s[1 : end+1]
+		s = s[end+2:]
+		return v, nil
+	}
+
+	if !space() {
+		return nil, errors.New("expected a name in DOCTYPE")
+	}
+
+	d := &Doctype{}
+	end := strings.IndexAny(s, " \t\r\n[")
+	if end < 0 {
+		end = len(s)
+	}
+	d.Name = s[:end]
+	s = s[end:]
+	if !isXMLName(strings.Replace(d.Name, ":", "_", -1)) {
+		return nil, fmt.Errorf("invalid name '%s' in DOCTYPE", d.Name)
+	}
+
+	var err error
+	space()
+	switch {
+	case strings.HasPrefix(s, "PUBLIC"):
+		s = s[len("PUBLIC"):]
+		if d.PublicID, err = literal(); err != nil {
+			return nil, err
+		}
+		if d.SystemID, err = literal(); err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(s, "SYSTEM"):
+		s = s[len("SYSTEM"):]
+		if d.SystemID, err = literal(); err != nil {
+			return nil, err
+		}
+	}
+
+	space()
+	if strings.HasPrefix(s, "[") {
+		end := strings.LastIndexByte(s, ']')
+		if end < 0 {
+			return nil, errors.New("unterminated internal subset in DOCTYPE")
+		}
+		d.InternalSubset = s[1:end]
+		s = s[end+1:]
+		space()
+	}
+
+	if s != "" {
+		return nil, fmt.Errorf("unexpected '%s' in DOCTYPE", s)
+	}
+	return d, nil
+}
+
+// endElement reads a '</prefix:name>' end element
+func (z *tokenizer) endElement() (token, error) {
+	start := z.pos
+	z.pos += 2
+
+	prefix, name, err := z.name()
+	if err != nil {
+		return token{}, err
+	}
+
+	z.skipSpace()
+	if z.pos >= len(z.src) || z.src[z.pos] != '>' {
+		return token{}, z.errorf(start, "invalid characters between </%s and >", joinPrefix(prefix, name))
+	}
+	z.pos++
+
+	return token{kind: endToken, prefix: prefix, name: name}, nil
+}
+
+// startElement reads a '<prefix:name attr="value">' start element, including its attributes
+func (z *tokenizer) startElement() (token, error) {
+	start := z.pos
+	z.pos++
+
+	prefix, name, err := z.name()
+	if err != nil {
+		return token{}, err
+	}
+	t := token{kind: startToken, prefix: prefix, name: name}
+
+	for {
+		space := z.skipSpace()
+		if z.pos >= len(z.src) {
+			return token{}, z.errorf(start, "unexpected EOF in element <%s>", joinPrefix(prefix, name))
+		}
+
+		switch z.src[z.pos] {
+		case '>':
+			z.pos++
+			return t, nil
+		case '/':
+			if z.pos+1 >= len(z.src) || z.src[z.pos+1] != '>' {
+				return token{}, z.errorf(z.pos, "expected '/>' in element <%s>", joinPrefix(prefix, name))
+			}
+			z.pos += 2
+			t.selfClosing = true
+			return t, nil
+		}
+
+		if !space {
+			return token{}, z.errorf(z.pos, "expected whitespace before attribute in element <%s>", joinPrefix(prefix, name))
+		}
+
+		attr, err := z.attribute()
+		if err != nil {
+			return token{}, err
+		}
+		t.attrs = append(t.attrs, attr)
+	}
+}
+
+// attribute reads a 'prefix:name="value"' attribute, decoding the references of its value
+func (z *tokenizer) attribute() (*Attribute, error) {
+	start := z.pos
+
+	prefix, name, err := z.name()
+	if err != nil {
+		return nil, err
+	}
+
+	z.skipSpace()
+	if z.pos >= len(z.src) || z.src[z.pos] != '=' {
+		return nil, z.errorf(start, "attribute '%s' without value", joinPrefix(prefix, name))
+	}
+	z.pos++
+	z.skipSpace()
+
+	if z.pos >= len(z.src) || z.src[z.pos] != '"' && z.src[z.pos] != '\'' {
+		return nil, z.errorf(z.pos, "unquoted value for attribute '%s'", joinPrefix(prefix, name))
+	}
+	quote := z.src[z.pos]
+
+	end := bytes.IndexByte(z.src[z.pos+1:], quote)
+	if end < 0 {
+		return nil, z.errorf(start, "unexpected EOF in value of attribute '%s'", joinPrefix(prefix, name))
+	}
+	raw := string(z.src[z.pos+1 : z.pos+1+end])
+	valueOffset := z.pos + 1
+	z.pos += end + 2
+
+	if i := strings.IndexByte(raw, '<'); i >= 0 {
+		return nil, z.errorf(valueOffset+i, "unescaped '<' in value of attribute '%s'", joinPrefix(prefix, name))
+	}
+
+	// literal whitespace is normalized to spaces, while whitespace written as a character reference is kept
+	value, err := decodeReferences(attributeSpaceReplacer.Replace(raw))
+	if err != nil {
+		return nil, z.errorf(valueOffset, "%s in value of attribute '%s'", err, joinPrefix(prefix, name))
+	}
+
+	attr := &Attribute{Prefix: prefix, Name: name, Value: value, Quote: quote}
+	if raw != value {
+		attr.raw, attr.decoded = raw, value
+	}
+	return attr, nil
+}
+
+// attributeSpaceReplacer normalizes the literal line endings and whitespace of an attribute value to spaces
+var attributeSpaceReplacer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", "\t", " ")
+
+// name reads an optionally prefixed XML name
+func (z *tokenizer) name() (string, string, error) {
+	start := z.pos
+
+	for z.pos < len(z.src) {
+		// ascii fast path
+		if c := z.src[z.pos]; c < utf8.RuneSelf {
+			if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.' || c == ':' {
+				z.pos++
+				continue
+			}
+			break
+		}
+
+		r, n := utf8.DecodeRune(z.src[z.pos:])
+		if !isNameChar(r) {
+			break
+		}
+		z.pos += n
+	}
+
+	qualified := string(z.src[start:z.pos])
+	prefix, name := "", qualified
+	if i := strings.IndexByte(qualified, ':'); i >= 0 {
+		prefix, name = qualified[:i], qualified[i+1:]
+	}
+
+	if !isXMLName(name) || prefix != "" && !isXMLName(prefix) {
+		return "", "", z.errorf(start, "invalid XML name '%s'", qualified)
+	}
+	return prefix, name, nil
+}
+
+// skipSpace advances past any whitespace and returns true if there was any
+func (z *tokenizer) skipSpace() bool {
+	start := z.pos
+	for z.pos < len(z.src) {
+		switch z.src[z.pos] {
+		case ' ', '\t', '\r', '\n':
+			z.pos++
+			continue
+		}
+		break
+	}
+	return z.pos > start
+}
+
+// joinPrefix returns 'prefix:name', or name when there is no prefix
+func joinPrefix(prefix, name string) string {
+	if prefix != "" {
+		return prefix + ":" + name
+	}
+	return name
+}
+
+// predefinedEntities are the entities every XML processor recognizes
+var predefinedEntities = map[string]string{
+	"lt":   "<",
+	"gt":   ">",
+	"amp":  "&",
+	"apos": "'",
+	"quot": "\"",
+}
+
+// resolveReference returns the replacement text of a reference name such as 'amp', '#169' or '#xA9'. false is
+// returned for entities that are not predefined.
+func resolveReference(name string) (string, bool) {
+	if v, ok := predefinedEntities[name]; ok {
+		return v, true
+	}
+
+	if !strings.HasPrefix(name, "#") {
+		return "", false
+	}
+
+	var n uint64
+	var err error
+	if strings.HasPrefix(name, "#x") {
+		n, err = strconv.ParseUint(name[2:], 16, 32)
+	} else {
+		n, err = strconv.ParseUint(name[1:], 10, 32)
+	}
+	if err != nil || !utf8.ValidRune(rune(n)) {
+		return "", false
+	}
+	return string(rune(n)), true
+}
+
+// splitReference returns the name of the reference at the start of s (which begins with '&') and its length
+func splitReference(s string) (string, int, error) {
+	end := strings.IndexByte(s, ';')
+	if end < 0 {
+		return "", 0, errors.New("unterminated reference")
+	}
+
+	name := s[1:end]
+	if name == "" || !strings.HasPrefix(name, "#") && !isXMLName(name) {
+		return "", 0, fmt.Errorf("invalid reference '%s'", s[:end+1])
+	}
+	return name, end + 1, nil
+}
+
+// decodeReferences replaces the predefined entities and character references in s. Other entity references are
+// kept as written, as their replacement text is not known.
+func decodeReferences(s string) (string, error) {
+	if strings.IndexByte(s, '&') < 0 {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '&')
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+
+		name, n, err := splitReference(s[i:])
+		if err != nil {
+			return "", err
+		}
+		if v, ok := resolveReference(name); ok {
+			b.WriteString(v)
+		} else {
+			b.WriteString(s[i : i+n])
+		}
+		s = s[i+n:]
+	}
+}
+
+// textElements converts undecoded character data into Elements. The references that Value writes back in the same
+// form (&amp; and &lt;) are decoded into the surrounding Value, every other reference becomes an EntityRef so that it
+// is written back as it was read. When decodeAll is set, only references to unknown entities are kept.
+func textElements(s string, decodeAll bool) ([]Element, error) {
+	var elements []Element
+	var b strings.Builder
+
+	flush := func() {
+		if b.Len() > 0 {
+			elements = append(elements, NewValue(b.String()))
+			b.Reset()
+		}
+	}
+
+	for {
+		i := strings.IndexByte(s, '&')
+		if i < 0 {
+			b.WriteString(s)
+			flush()
+			return elements, nil
+		}
+		b.WriteString(s[:i])
+
+		name, n, err := splitReference(s[i:])
+		if err != nil {
+			return nil, err
+		}
+
+		v, ok := resolveReference(name)
+		if ok && (decodeAll || name == "amp" || name == "lt") {
+			b.WriteString(v)
+		} else {
+			flush()
+			elements = append(elements, NewEntityRef(name))
+		}
+		s = s[i+n:]
+	}
+}
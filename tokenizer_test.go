@@ -0,0 +1,179 @@
+package simplexml
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func TestTokenizer(t *testing.T) {
+	Convey("Given a document using single quotes, references, CDATA and prefixes", t, func() {
+		x := `<?xml version='1.0'  encoding='UTF-8' standalone='yes' ?><a:root xmlns:a='urn:a' b="it's"><v>caf&#233; &amp; &quot;bar&quot; &nbsp; 1 &lt; 2 &gt; 0</v><c><![CDATA[<raw> & ]]></c><a:d e='x"y'/></a:root>`
+		d, err := NewDocumentFromReader(strings.NewReader(x))
+		So(err, ShouldBeNil)
+
+		Convey("Marshal should reproduce the source", func() {
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, x)
+		})
+
+		Convey("A changed Declaration should be written from its fields", func() {
+			d.Declaration.Standalone = "no"
+			b, err := d.Marshal()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldStartWith, `<?xml version="1.0" encoding="UTF-8" standalone="no"?><a:root`)
+		})
+
+		Convey("Values should be decoded", func() {
+			v, err := d.Root().Search().ByName("v").One().Value()
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, "café & \"bar\" &nbsp; 1 < 2 > 0")
+
+			So(d.Root().Attributes[1].Value, ShouldEqual, "it's")
+			So(d.Root().Attributes[1].Quote, ShouldEqual, '"')
+		})
+
+		Convey("References other than &amp; and &lt; should be EntityRef elements", func() {
+			v := d.Root().Search().ByName("v").One()
+			So(v.elements[1], ShouldHaveSameTypeAs, NewEntityRef(""))
+			So(v.elements[1].String(), ShouldEqual, "&#233;")
+		})
+	})
+
+	Convey("Given attribute values with references and literal whitespace", t, func() {
+		x := "<a b=\"x&#xA;y\" c=\"&#169; &amp; x\" d=\"x\r\ny\tz\"/>"
+		d, err := NewDocumentFromReader(strings.NewReader(x))
+		So(err, ShouldBeNil)
+		root := d.Root()
+
+		Convey("Literal whitespace should be normalized while references are decoded", func() {
+			So(root.Attr("b").Value, ShouldEqual, "x\ny")
+			So(root.Attr("c").Value, ShouldEqual, "© & x")
+			So(root.Attr("d").Value, ShouldEqual, "x y z")
+		})
+
+		Convey("Marshal should write unchanged values as they were read", func() {
+			b, err := d.MarshalWithOptions(MarshalOptions{OmitDeclaration: true})
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, x)
+		})
+
+		Convey("Changed values should be escaped and read back unchanged", func() {
+			root.SetAttr("b", root.Attr("b").Value+"\tz")
+			root.Attr("c").Quote = '\''
+			b, err := d.MarshalWithOptions(MarshalOptions{OmitDeclaration: true})
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "<a b=\"x&#xA;y&#x9;z\" c='&#169; &amp; x' d=\"x\r\ny\tz\"/>")
+
+			again, err := NewDocumentFromReader(bytes.NewReader(b))
+			So(err, ShouldBeNil)
+			for _, attr := range root.Attributes {
+				So(again.Root().Attr(attr.Name).Value, ShouldEqual, attr.Value)
+			}
+		})
+	})
+
+	Convey("Given a CDATA section containing text that looks like references", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(`<Ad><Impression><![CDATA[http://t/imp?a=1&copy=2&reg=3&lt=4&amp;]]></Impression></Ad>`))
+		So(err, ShouldBeNil)
+		imp := d.Root().Search().ByName("Impression").One()
+		url := "http://t/imp?a=1&copy=2&reg=3&lt=4&amp;"
+
+		Convey("Value, TextContent and XPath string() should return the content as written", func() {
+			v, err := imp.Value()
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, url)
+			So(imp.TextContent(), ShouldEqual, url)
+
+			s, err := MustCompile("string(Impression)").Evaluate(d.Root())
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, url)
+
+			v, err = NewCDATA("a &amp; b").Value()
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, "a &amp; b")
+		})
+	})
+
+	Convey("Given malformed documents", t, func() {
+		for _, x := range []string{
+			`<root a=1/>`,
+			`<root><!-- -- --></root>`,
+			`<root a="<"/>`,
+			`<root>&bad</root>`,
+			`<root><![CDATA[</root>`,
+			`<1root/>`,
+		} {
+			_, err := NewDocumentFromReader(strings.NewReader(x))
+
+			Convey(fmt.Sprintf("'%s' should return a *ParseError", x), func() {
+				So(err, ShouldHaveSameTypeAs, &ParseError{})
+			})
+		}
+	})
+}
+
+// benchmarkDocument returns a document of n items using attributes, text, CDATA and comments
+func benchmarkDocument(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><feed xmlns:m="urn:m">`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `<item id="%d" type='video'><!-- item %d --><title>Title &amp; more %d</title><m:url><![CDATA[http://x/%d?a=1&b=2]]></m:url></item>`, i, i, i, i)
+	}
+	b.WriteString(`</feed>`)
+	return b.Bytes()
+}
+
+func BenchmarkNewDocumentFromReader(b *testing.B) {
+	src := benchmarkDocument(1000)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := NewDocumentFromReader(bytes.NewReader(src)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTokenizer(b *testing.B) {
+	src := benchmarkDocument(1000)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		z := newTokenizer(src)
+		for {
+			_, ok, err := z.next()
+			if err != nil {
+				b.Fatal(err)
+			} else if !ok {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkXMLDecoder(b *testing.B) {
+	src := benchmarkDocument(1000)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		d := xml.NewDecoder(bytes.NewReader(src))
+		for {
+			_, err := d.RawToken()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
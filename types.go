@@ -2,13 +2,13 @@ package simplexml
 
 import (
 	"fmt"
-	"html"
+	"io"
+	"reflect"
 	"strings"
 )
 
-const (
-	DefaultDeclaration = "<?xml version=\"1.0\" encding=\"UTF-8\"?>"
-)
+// XMLNamespace is the namespace bound to the predefined 'xml' prefix (eg. 'xml:lang')
+const XMLNamespace = "http://www.w3.org/XML/1998/namespace"
 
 type Element interface {
 	// String returns the elements string repesentation, including the elements wrapping markup, usually with HTML encoding
@@ -18,12 +18,136 @@ type Element interface {
 	Value() (string, error)
 }
 
+// xmlWriter is implemented by the buffers used to serialize Elements (bufio.Writer, bytes.Buffer and
+// strings.Builder). Write errors are sticky in bufio.Writer and are checked once on Flush.
+type xmlWriter interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// writeElement writes an Element to w, streaming Tags rather than building their string representation
+func writeElement(w xmlWriter, e Element) {
+	if t, ok := e.(*Tag); ok {
+		t.writeTo(w)
+		return
+	}
+	w.WriteString(e.String())
+}
+
+// elementIndex returns the position of the element with the same memory address as e, or -1 if there is none
+func elementIndex(elements []Element, e Element) int {
+	for k, v := range elements {
+		if samePointer(v, e) {
+			return k
+		}
+	}
+	return -1
+}
+
+// siblingElement returns the element step positions away from e, or nil if there is none
+func siblingElement(elements []Element, e Element, step int) Element {
+	loc := elementIndex(elements, e)
+	if loc < 0 || loc+step < 0 || loc+step >= len(elements) {
+		return nil
+	}
+	return elements[loc+step]
+}
+
+// siblingTag returns the nearest *Tag in the direction of step from e, or nil if there is none
+func siblingTag(elements []Element, e Element, step int) *Tag {
+	loc := elementIndex(elements, e)
+	if loc < 0 {
+		return nil
+	}
+
+	for i := loc + step; i >= 0 && i < len(elements); i += step {
+		if t, ok := elements[i].(*Tag); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// elementAt returns the element at index i, or nil if i is out of range
+func elementAt(elements []Element, i int) Element {
+	if i < 0 || i >= len(elements) {
+		return nil
+	}
+	return elements[i]
+}
+
+// tagAt returns the *Tag at index i of the Tags within elements, or nil if i is out of range
+func tagAt(elements []Element, i int) *Tag {
+	if i < 0 {
+		return nil
+	}
+
+	for _, v := range elements {
+		if t, ok := v.(*Tag); ok {
+			if i == 0 {
+				return t
+			}
+			i--
+		}
+	}
+	return nil
+}
+
+// tagIndex returns the index of t among the Tags within elements, or -1 if it is not found
+func tagIndex(elements []Element, t *Tag) int {
+	i := 0
+	for _, v := range elements {
+		if c, ok := v.(*Tag); ok {
+			if c == t {
+				return i
+			}
+			i++
+		}
+	}
+	return -1
+}
+
+// samePointer returns true if a and b are pointers to the same memory address, and false if either is nil or not a
+// pointer
+func samePointer(a, b Element) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Kind() != reflect.Ptr || vb.Kind() != reflect.Ptr || va.IsNil() || vb.IsNil() {
+		return false
+	}
+	return va.Pointer() == vb.Pointer()
+}
+
+// countWriter wraps an io.Writer and counts the bytes written to it
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // Value is a string representation of XML CharData
 type Value string
 
-// String implements the Stringer interface. String returns the html escaped value of Value.
+// String implements the Stringer interface. String returns the escaped value of Value, where '&', '<' and the '>'
+// of ']]>' are replaced by entity references.
 func (v Value) String() string {
-	return html.EscapeString(string(v))
+	return escapeText(string(v))
+}
+
+// textEscaper replaces the characters that may not appear literally in XML character data
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "]]>", "]]&gt;")
+
+// escapeText escapes s for use as XML character data
+func escapeText(s string) string {
+	if !strings.ContainsAny(s, "&<>") {
+		return s
+	}
+	return textEscaper.Replace(s)
 }
 
 // Value implements the Stringer interface. String returns the html escaped value of Value.
@@ -39,7 +163,8 @@ func (c CDATA) String() string {
 	return fmt.Sprintf("<![CDATA[%s]]>", string(c))
 }
 
-// String implements the Stringer interface. String returns the html escaped value of Value wrapped the CDATA markup.
+// Value returns the value of CDATA as written, without the CDATA markup. The content of CDATA is literal, references
+// within it are not unescaped.
 func (c CDATA) Value() (string, error) {
 	return string(c), nil
 }
@@ -57,6 +182,226 @@ func (c Comment) Value() (string, error) {
 	return string(c), nil
 }
 
+// EntityRef is an XML entity or character reference without the '&' and ';' markup (eg. 'nbsp', '#169' or '#xA9').
+// EntityRefs keep references that can not be written back in the same form from a Value.
+type EntityRef string
+
+// String implements the Stringer interface. String returns the reference with its markup.
+func (e EntityRef) String() string {
+	return fmt.Sprintf("&%s;", string(e))
+}
+
+// Value returns the replacement text of a predefined entity or character reference. References to other entities
+// can not be resolved without a DTD and are returned with their markup.
+func (e EntityRef) Value() (string, error) {
+	if v, ok := resolveReference(string(e)); ok {
+		return v, nil
+	}
+	return e.String(), nil
+}
+
+// NewEntityRef returns a pointer to a new EntityRef
+func NewEntityRef(s string) *EntityRef {
+	e := new(EntityRef)
+	*e = EntityRef(s)
+	return e
+}
+
+// Whitespace is whitespace only character data kept by the PreserveWhitespace ParseOption or an xml:space attribute.
+// Whitespace is written back verbatim and is left in place when a Tag is indented.
+type Whitespace string
+
+// String implements the Stringer interface. String returns the whitespace as is.
+func (w Whitespace) String() string {
+	return string(w)
+}
+
+// Value returns the whitespace as is.
+func (w Whitespace) Value() (string, error) {
+	return string(w), nil
+}
+
+// NewWhitespace returns a pointer to a new Whitespace
+func NewWhitespace(s string) *Whitespace {
+	w := new(Whitespace)
+	*w = Whitespace(s)
+	return w
+}
+
+// ProcInst is an XML processing instruction ('<?target data?>'), such as an xml-stylesheet instruction. The XML
+// declaration is not a ProcInst, it is kept in the Declaration of a Document.
+type ProcInst struct {
+	Target string
+	Data   string
+}
+
+// String implements the Stringer interface. String returns the processing instruction with its markup.
+func (p ProcInst) String() string {
+	if p.Data == "" {
+		return fmt.Sprintf("<?%s?>", p.Target)
+	}
+	return fmt.Sprintf("<?%s %s?>", p.Target, p.Data)
+}
+
+// Value returns the data of the processing instruction.
+func (p ProcInst) Value() (string, error) {
+	return p.Data, nil
+}
+
+// valid returns false if the target is not a name, is reserved ('xml' in any case) or the data contains '?>'
+func (p ProcInst) valid() bool {
+	return isXMLName(strings.Replace(p.Target, ":", "_", -1)) && !strings.EqualFold(p.Target, "xml") &&
+		!strings.Contains(p.Data, "?>")
+}
+
+// NewProcInst returns a pointer to a new ProcInst
+func NewProcInst(target, data string) *ProcInst {
+	return &ProcInst{Target: target, Data: data}
+}
+
+// Declaration is the XML declaration of a Document ('<?xml version="1.0" encoding="UTF-8" standalone="yes"?>').
+// Encoding and Standalone are optional and are not written when empty, Standalone is either 'yes' or 'no'.
+type Declaration struct {
+	Version    string
+	Encoding   string
+	Standalone string
+
+	// raw is the declaration as written in the source and parsed the values it was read as. raw is written in place
+	// of the fields for as long as they are unchanged, which keeps its quotes and spacing.
+	raw    string
+	parsed [3]string
+}
+
+// NewDeclaration returns a pointer to a new XML 1.0 declaration for a UTF-8 document
+func NewDeclaration() *Declaration {
+	return &Declaration{Version: "1.0", Encoding: "UTF-8"}
+}
+
+// String implements the Stringer interface. String returns the declaration with its markup, a missing Version is
+// written as '1.0'. A parsed declaration whose fields are unchanged is written as it was read.
+func (d Declaration) String() string {
+	if d.raw != "" && d.parsed == [3]string{d.Version, d.Encoding, d.Standalone} {
+		return d.raw
+	}
+
+	version := d.Version
+	if version == "" {
+		version = "1.0"
+	}
+
+	s := fmt.Sprintf("<?xml version=\"%s\"", version)
+	if d.Encoding != "" {
+		s += fmt.Sprintf(" encoding=\"%s\"", d.Encoding)
+	}
+	if d.Standalone != "" {
+		s += fmt.Sprintf(" standalone=\"%s\"", d.Standalone)
+	}
+	return s + "?>"
+}
+
+// keepSource records raw as the source text of the declaration, to be written while its fields are unchanged
+func (d *Declaration) keepSource(raw string) {
+	d.raw, d.parsed = raw, [3]string{d.Version, d.Encoding, d.Standalone}
+}
+
+// Errors returns an error for each pseudo attribute of the declaration with an invalid value.
+func (d Declaration) Errors() []error {
+	var errs []error
+
+	if d.Version != "" && !validVersion(d.Version) {
+		errs = append(errs, &ValidationError{Msg: fmt.Sprintf("invalid XML version '%s'", d.Version)})
+	}
+	if d.Encoding != "" && !validEncodingName(d.Encoding) {
+		errs = append(errs, &ValidationError{Msg: fmt.Sprintf("invalid encoding name '%s'", d.Encoding)})
+	}
+	if d.Standalone != "" && d.Standalone != "yes" && d.Standalone != "no" {
+		errs = append(errs, &ValidationError{Msg: fmt.Sprintf("invalid standalone value '%s'", d.Standalone)})
+	}
+
+	return errs
+}
+
+// validVersion returns true if s is a version number of the form '1.x'
+func validVersion(s string) bool {
+	if len(s) < 3 || !strings.HasPrefix(s, "1.") {
+		return false
+	}
+	for _, c := range s[2:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validEncodingName returns true if s is an encoding name as defined by the XML specification
+func validEncodingName(s string) bool {
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-'):
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Doctype is an XML document type declaration ('<!DOCTYPE name PUBLIC "public" "system" [subset]>'). The internal
+// subset is kept as written and is not interpreted.
+type Doctype struct {
+	Name           string
+	PublicID       string
+	SystemID       string
+	InternalSubset string
+}
+
+// String implements the Stringer interface. String returns the document type declaration with its markup. A
+// PublicID is only written along with a SystemID, as XML requires both. Marshaling a Document returns an error for a
+// PublicID without one rather than dropping it.
+func (d Doctype) String() string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE ")
+	b.WriteString(d.Name)
+
+	if d.PublicID != "" && d.SystemID != "" {
+		b.WriteString(" PUBLIC ")
+		b.WriteString(quoteLiteral(d.PublicID))
+		b.WriteByte(' ')
+		b.WriteString(quoteLiteral(d.SystemID))
+	} else if d.SystemID != "" {
+		b.WriteString(" SYSTEM ")
+		b.WriteString(quoteLiteral(d.SystemID))
+	}
+
+	if d.InternalSubset != "" {
+		b.WriteString(" [")
+		b.WriteString(d.InternalSubset)
+		b.WriteByte(']')
+	}
+
+	b.WriteByte('>')
+	return b.String()
+}
+
+// Value returns an empty string, a Doctype does not have a value.
+func (d Doctype) Value() (string, error) {
+	return "", nil
+}
+
+// quoteLiteral wraps s in double quotes, or single quotes if it contains a double quote
+func quoteLiteral(s string) string {
+	if strings.Contains(s, `"`) {
+		return "'" + s + "'"
+	}
+	return `"` + s + `"`
+}
+
+// NewDoctype returns a pointer to a new Doctype, publicID and systemID may be empty
+func NewDoctype(name, publicID, systemID string) *Doctype {
+	return &Doctype{Name: name, PublicID: publicID, SystemID: systemID}
+}
+
 // NewComment returns a pointer to a new Comment
 func NewComment(s string) *Comment {
 	c := new(Comment)
@@ -86,29 +431,88 @@ func NeedCDATA(s string) bool {
 	return false
 }
 
+// isXMLChar returns true if r is allowed in an XML 1.0 document
+func isXMLChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		r >= 0x20 && r <= 0xD7FF || r >= 0xE000 && r <= 0xFFFD || r >= 0x10000 && r <= 0x10FFFF
+}
+
+// isXMLName returns true if s is a valid XML name without a prefix
+func isXMLName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if i == 0 && !isNameStart(r) || !isNameChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
 // Attribute is a simple representations of an XML attrbiute, consiting of a prefix, name and value.
 type Attribute struct {
 	Prefix string
 	Name   string
 	Value  string
+
+	// Quote is the character written around Value, either '"' or '\''. A zero value is written as '"'.
+	Quote byte
+
+	// raw is the value as written in the source and decoded the Value it was parsed as. raw is written in place of
+	// Value for as long as Value is unchanged, which keeps character references as they were read.
+	raw, decoded string
 }
 
-// IsNamespace returns true if it's prefix = 'xmlns' (not case sensitive)
+// IsNamespace returns true if it's prefix = 'xmlns' or it is a default namespace declaration (name = 'xmlns'
+// without a prefix), not case sensitive
 func (a Attribute) IsNamespace() bool {
-	if strings.ToLower(a.Prefix) == "xmlns" {
+	if strings.ToLower(a.Prefix) == "xmlns" || a.Prefix == "" && strings.ToLower(a.Name) == "xmlns" {
 		return true
 	}
 	return false
 }
 
-// String returns a format for use within String() of Tag
+// declaredPrefix returns the prefix declared by a namespace Attribute, an empty string for a default namespace
+func (a Attribute) declaredPrefix() string {
+	if a.Prefix == "" {
+		return ""
+	}
+	return a.Name
+}
+
+// String returns a format for use within String() of Tag. A value that is unchanged since it was parsed is written as
+// it was read, any other value is escaped, replacing '&', '<', the quote character and whitespace other than a space
+// (which would otherwise be normalized by a parser) with references.
 func (a Attribute) String() string {
+	quote := a.Quote
+	if quote == 0 {
+		quote = '"'
+	}
+
+	value := a.Value
+	if a.raw != "" && a.decoded == value && strings.IndexByte(a.raw, quote) < 0 {
+		value = a.raw
+	} else if strings.ContainsAny(value, "&<\"'\t\n\r") {
+		if quote == '\'' {
+			value = singleQuoteEscaper.Replace(value)
+		} else {
+			value = doubleQuoteEscaper.Replace(value)
+		}
+	}
+
 	if a.Prefix != "" {
-		return fmt.Sprintf("%s:%s=\"%s\"", a.Prefix, a.Name, a.Value)
+		return fmt.Sprintf("%s:%s=%c%s%c", a.Prefix, a.Name, quote, value, quote)
 	}
-	return fmt.Sprintf("%s=\"%s\"", a.Name, a.Value)
+	return fmt.Sprintf("%s=%c%s%c", a.Name, quote, value, quote)
 }
 
+// doubleQuoteEscaper and singleQuoteEscaper escape attribute values for the quote character they are written in
+var (
+	doubleQuoteEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+	singleQuoteEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "'", "&apos;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
 // XPath is a slice of string (of Tag names)
 type XPath []string
 
@@ -0,0 +1,309 @@
+package simplexml
+
+import (
+	"encoding"
+	"encoding/xml"
+	"reflect"
+	"strings"
+)
+
+// UnmarshalResult is returned during UnmarshalStrict(), containing a slice of XPath that were and were not
+// successfully Unmarshaled. Attributes are represented by a last XPath element of '@name'.
+type UnmarshalResult struct {
+	Used   []XPath
+	Unused []XPath
+}
+
+// UnmarshalStrict is a custom XML unmarshaller that behaves much like xml.Unmarshal with a few enahncements,
+// including the return of a UnmarshalResult. v is decoded with encoding/xml so that struct tags behave exactly as
+// they do with xml.Unmarshal, the Tag tree is then compared against the struct fields to find which Tags and
+// Attributes have a destination and which were ignored. Fields with a namespace ('namespace-URL name') only match
+// Tags and Attributes in that namespace, resolved through the ancestors of the Tag. Entity references are decoded
+// to the text Value returns. Namespace declarations are not reported.
+func (t *Tag) UnmarshalStrict(v interface{}) (UnmarshalResult, error) {
+	var r UnmarshalResult
+
+	// the clone declares the namespaces in scope, references encoding/xml can not resolve are replaced by their text
+	c := t.Clone()
+	resolveEntityRefs(c)
+	b, err := c.Marshal()
+	if err != nil {
+		return r, err
+	}
+
+	if err := xml.Unmarshal(b, v); err != nil {
+		return r, err
+	}
+
+	u := &unmarshalWalker{used: map[string]bool{}, unused: map[string]bool{}, result: &r}
+	u.walk(t, t.XPath(), reflect.TypeOf(v))
+
+	return r, nil
+}
+
+// resolveEntityRefs replaces the EntityRefs within t and its descendants by a Value of their text
+func resolveEntityRefs(t *Tag) {
+	for k, e := range t.elements {
+		switch v := e.(type) {
+		case *EntityRef:
+			text, _ := v.Value()
+			t.elements[k] = NewValue(text)
+		case *Tag:
+			resolveEntityRefs(v)
+		}
+	}
+}
+
+var (
+	unmarshalerType     = reflect.TypeOf((*xml.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// unmarshalField is a struct field as seen by encoding/xml. path holds the element names of a 'a>b>c' tag, space
+// the namespace of the last element or attribute, if any.
+type unmarshalField struct {
+	name     string
+	space    string
+	path     []string
+	typ      reflect.Type
+	attr     bool
+	any      bool
+	innerXML bool
+}
+
+// unmarshalWalker walks a Tag tree alongside a Go type and records the paths of the result once each
+type unmarshalWalker struct {
+	used   map[string]bool
+	unused map[string]bool
+	result *UnmarshalResult
+}
+
+func (u *unmarshalWalker) use(path XPath) {
+	if s := path.String(); !u.used[s] {
+		u.used[s] = true
+		u.result.Used = append(u.result.Used, path)
+	}
+}
+
+func (u *unmarshalWalker) ignore(path XPath) {
+	if s := path.String(); !u.unused[s] {
+		u.unused[s] = true
+		u.result.Unused = append(u.result.Unused, path)
+	}
+}
+
+// walk records t as used and compares its attributes and children against typ
+func (u *unmarshalWalker) walk(t *Tag, path XPath, typ reflect.Type) {
+	u.use(path)
+
+	typ = unmarshalElemType(typ)
+
+	// types that unmarshal themselves consume the whole subtree
+	if reflect.PtrTo(typ).Implements(unmarshalerType) {
+		u.subtree(t, path, u.use)
+		return
+	}
+
+	// anything that is not a struct only receives character data
+	if typ.Kind() != reflect.Struct || reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		u.attributes(t, path, nil)
+		for _, c := range t.Tags() {
+			u.subtree(c, childPath(path, c), u.ignore)
+		}
+		return
+	}
+
+	fields := unmarshalFields(typ)
+	u.attributes(t, path, fields)
+
+	for _, f := range fields {
+		if f.innerXML {
+			for _, c := range t.Tags() {
+				u.subtree(c, childPath(path, c), u.use)
+			}
+			return
+		}
+	}
+
+	u.children(t, path, fields)
+}
+
+// children matches the child Tags of t against the element fields, descending into 'a>b' paths
+func (u *unmarshalWalker) children(t *Tag, path XPath, fields []unmarshalField) {
+	for _, c := range t.Tags() {
+		cp := childPath(path, c)
+
+		var nested []unmarshalField
+		var match, anyField *unmarshalField
+		for i, f := range fields {
+			switch {
+			case f.attr || f.innerXML:
+			case f.any:
+				if anyField == nil {
+					anyField = &fields[i]
+				}
+			case len(f.path) > 0 && f.path[0] == c.Name:
+				if len(f.path) == 1 {
+					if match == nil && f.inSpace(c.NamespaceURI()) {
+						match = &fields[i]
+					}
+				} else {
+					n := f
+					n.path = f.path[1:]
+					nested = append(nested, n)
+				}
+			}
+		}
+
+		switch {
+		case match != nil:
+			u.walk(c, cp, match.typ)
+		case len(nested) > 0:
+			u.use(cp)
+			u.attributes(c, cp, nil)
+			u.children(c, cp, nested)
+		case anyField != nil:
+			u.walk(c, cp, anyField.typ)
+		default:
+			u.subtree(c, cp, u.ignore)
+		}
+	}
+}
+
+// attributes matches the attributes of t against the attribute fields
+func (u *unmarshalWalker) attributes(t *Tag, path XPath, fields []unmarshalField) {
+	for _, attr := range t.Attributes {
+		if attr.IsNamespace() {
+			continue
+		}
+
+		// an unprefixed Attribute is in no namespace
+		space, err := "", error(nil)
+		if attr.Prefix != "" {
+			space, err = t.GetNamespace(attr.Prefix)
+		}
+
+		used := false
+		for _, f := range fields {
+			if f.attr && (f.any || f.name == attr.Name && f.inSpace(space, err)) {
+				used = true
+				break
+			}
+		}
+
+		if used {
+			u.use(attributePath(path, attr))
+		} else {
+			u.ignore(attributePath(path, attr))
+		}
+	}
+}
+
+// inSpace returns true if a Tag or Attribute in the namespace space can be decoded into the field. A field without a
+// namespace accepts any.
+func (f unmarshalField) inSpace(space string, err error) bool {
+	return f.space == "" || err == nil && f.space == space
+}
+
+// subtree records t, its attributes and all of its descendants with record
+func (u *unmarshalWalker) subtree(t *Tag, path XPath, record func(XPath)) {
+	record(path)
+	for _, attr := range t.Attributes {
+		if !attr.IsNamespace() {
+			record(attributePath(path, attr))
+		}
+	}
+	for _, c := range t.Tags() {
+		u.subtree(c, childPath(path, c), record)
+	}
+}
+
+// childPath returns a copy of path with the name of c appended
+func childPath(path XPath, c *Tag) XPath {
+	return append(append(XPath{}, path...), c.qualifiedName())
+}
+
+// attributePath returns a copy of path with '@name' appended for attr
+func attributePath(path XPath, attr *Attribute) XPath {
+	name := attr.Name
+	if attr.Prefix != "" {
+		name = attr.Prefix + ":" + name
+	}
+	return append(append(XPath{}, path...), "@"+name)
+}
+
+// unmarshalElemType dereferences pointers and slices (other than []byte) to the type an element is decoded into
+func unmarshalElemType(typ reflect.Type) reflect.Type {
+	for {
+		switch {
+		case typ.Kind() == reflect.Ptr:
+			typ = typ.Elem()
+		case typ.Kind() == reflect.Slice && typ.Elem().Kind() != reflect.Uint8:
+			typ = typ.Elem()
+		default:
+			return typ
+		}
+	}
+}
+
+// unmarshalFields returns the fields of a struct type that encoding/xml decodes into, including those of embedded
+// structs
+func unmarshalFields(typ reflect.Type) []unmarshalField {
+	var fields []unmarshalField
+
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag := sf.Tag.Get("xml")
+
+		if sf.Name == "XMLName" || tag == "-" {
+			continue
+		}
+
+		if sf.Anonymous && tag == "" {
+			if et := unmarshalElemType(sf.Type); et.Kind() == reflect.Struct {
+				fields = append(fields, unmarshalFields(et)...)
+				continue
+			}
+		}
+
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		f := unmarshalField{name: sf.Name, typ: sf.Type}
+		tokens := strings.Split(tag, ",")
+		if tokens[0] != "" {
+			// split the namespace of 'namespace-URL name'
+			name := tokens[0]
+			if i := strings.LastIndex(name, " "); i >= 0 {
+				f.space, name = name[:i], name[i+1:]
+			}
+			f.name = name
+		}
+
+		chardata := false
+		for _, flag := range tokens[1:] {
+			switch flag {
+			case "attr":
+				f.attr = true
+			case "any":
+				f.any = true
+			case "innerxml":
+				f.innerXML = true
+			case "chardata", "cdata", "comment":
+				chardata = true
+			}
+		}
+
+		if chardata {
+			continue
+		}
+
+		if !f.attr {
+			f.path = strings.Split(f.name, ">")
+		}
+		fields = append(fields, f)
+	}
+
+	return fields
+}
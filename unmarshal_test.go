@@ -0,0 +1,143 @@
+package simplexml
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+
+	"strings"
+)
+
+type unmarshalCreative struct {
+	ID       string `xml:"id,attr"`
+	Duration string `xml:"Linear>Duration"`
+	Media    []struct {
+		URL string `xml:",chardata"`
+	} `xml:"Linear>MediaFiles>MediaFile"`
+}
+
+type unmarshalAd struct {
+	XMLName   struct{}            `xml:"Ad"`
+	ID        string              `xml:"id,attr"`
+	Title     string              `xml:"AdTitle"`
+	Creatives []unmarshalCreative `xml:"Creatives>Creative"`
+}
+
+type unmarshalNamespacedItem struct {
+	X     string `xml:"urn:a x"`
+	Y     string `xml:"urn:d y"`
+	Other string `xml:"urn:other z"`
+	Kind  string `xml:"urn:a kind,attr"`
+	Text  string `xml:"text"`
+}
+
+func TestUnmarshalStrict(t *testing.T) {
+	Convey("Given a Tag with fields that are and are not mapped by a struct", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(`<VAST>
+	<Ad id="1" sequence="2">
+		<AdTitle>Title</AdTitle>
+		<Pricing model="cpm">1.5</Pricing>
+		<Creatives>
+			<Creative id="c1">
+				<Linear>
+					<Duration>00:00:15</Duration>
+					<Icons><Icon/></Icons>
+					<MediaFiles><MediaFile type="video/mp4">a.mp4</MediaFile><MediaFile>b.mp4</MediaFile></MediaFiles>
+				</Linear>
+			</Creative>
+		</Creatives>
+	</Ad>
+</VAST>`))
+		So(err, ShouldBeNil)
+		ad := d.Root().Search().ByName("Ad").One()
+
+		var v unmarshalAd
+		r, err := ad.UnmarshalStrict(&v)
+
+		Convey("The struct should be decoded like xml.Unmarshal", func() {
+			So(err, ShouldBeNil)
+			So(v.ID, ShouldEqual, "1")
+			So(v.Title, ShouldEqual, "Title")
+			So(len(v.Creatives), ShouldEqual, 1)
+			So(v.Creatives[0].Duration, ShouldEqual, "00:00:15")
+			So(len(v.Creatives[0].Media), ShouldEqual, 2)
+			So(v.Creatives[0].Media[1].URL, ShouldEqual, "b.mp4")
+		})
+
+		Convey("Used should contain each mapped path once", func() {
+			var used []string
+			for _, p := range r.Used {
+				used = append(used, p.String())
+			}
+			So(used, ShouldResemble, []string{
+				"/VAST/Ad",
+				"/VAST/Ad/@id",
+				"/VAST/Ad/AdTitle",
+				"/VAST/Ad/Creatives",
+				"/VAST/Ad/Creatives/Creative",
+				"/VAST/Ad/Creatives/Creative/@id",
+				"/VAST/Ad/Creatives/Creative/Linear",
+				"/VAST/Ad/Creatives/Creative/Linear/Duration",
+				"/VAST/Ad/Creatives/Creative/Linear/MediaFiles",
+				"/VAST/Ad/Creatives/Creative/Linear/MediaFiles/MediaFile",
+			})
+		})
+
+		Convey("Unused should contain each ignored path once", func() {
+			var unused []string
+			for _, p := range r.Unused {
+				unused = append(unused, p.String())
+			}
+			So(unused, ShouldResemble, []string{
+				"/VAST/Ad/@sequence",
+				"/VAST/Ad/Pricing",
+				"/VAST/Ad/Pricing/@model",
+				"/VAST/Ad/Creatives/Creative/Linear/Icons",
+				"/VAST/Ad/Creatives/Creative/Linear/Icons/Icon",
+				"/VAST/Ad/Creatives/Creative/Linear/MediaFiles/MediaFile/@type",
+			})
+		})
+	})
+
+	Convey("Given a namespaced Tag whose namespaces are declared on its ancestors", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(`<root xmlns="urn:d" xmlns:a="urn:a" xmlns:b="urn:b">` +
+			`<a:item a:kind="k" b:kind="other"><a:x>1</a:x><y>2</y><z>3</z><text>a&nbsp;b &amp; c</text></a:item></root>`))
+		So(err, ShouldBeNil)
+		item := d.Root().Tags()[0]
+
+		var v unmarshalNamespacedItem
+		r, err := item.UnmarshalStrict(&v)
+
+		Convey("Fields should be decoded through the declarations in scope", func() {
+			So(err, ShouldBeNil)
+			So(v.X, ShouldEqual, "1")
+			So(v.Y, ShouldEqual, "2")
+			So(v.Other, ShouldBeEmpty)
+			So(v.Kind, ShouldEqual, "k")
+		})
+
+		Convey("Entity references should be decoded to their text", func() {
+			So(v.Text, ShouldEqual, "a&nbsp;b & c")
+		})
+
+		Convey("Tags and Attributes in another namespace should be Unused", func() {
+			var used, unused []string
+			for _, p := range r.Used {
+				used = append(used, p.String())
+			}
+			for _, p := range r.Unused {
+				unused = append(unused, p.String())
+			}
+			So(used, ShouldResemble, []string{"/root/a:item", "/root/a:item/@a:kind", "/root/a:item/a:x", "/root/a:item/y", "/root/a:item/text"})
+			So(unused, ShouldResemble, []string{"/root/a:item/@b:kind", "/root/a:item/z"})
+		})
+	})
+
+	Convey("Given a Tag that does not match the XMLName of the struct", t, func() {
+		var v unmarshalAd
+		_, err := NewTag("NotAd").UnmarshalStrict(&v)
+
+		Convey("An error should be returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
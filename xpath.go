@@ -0,0 +1,1860 @@
+package simplexml
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Expr is a compiled XPath 1.0 expression that can be evaluated against any number of Tags and Documents.
+//
+// Unprefixed name tests (eg. 'item') match on local name and ignore the namespace of the element, like
+// Search.ByName. Prefixed name tests (eg. 'atom:link') are resolved through GetNamespace of the Tag the expression is
+// evaluated against and match elements whose prefix resolves to the same namespace, falling back to a comparison of
+// the literal prefix when the namespace is not available.
+//
+// Besides the XPath 1.0 core library, the functions lower-case(s), upper-case(s) and matches(s, pattern, flags?) of
+// XPath 2.0 and glob(s, pattern), which uses the patterns of path.Match, are available to match names that differ
+// between producers (eg. '//*[lower-case(local-name()) = "impressionurl"]').
+type Expr struct {
+	expr string
+	root xpathExpr
+}
+
+// Compile parses an XPath 1.0 expression and returns an Expr that can be used to evaluate it.
+func Compile(expr string) (*Expr, error) {
+	tokens, err := lexXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &xpathParser{expr: expr, tokens: tokens}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Expr{expr: expr, root: root}, nil
+}
+
+// MustCompile is like Compile but panics if the expression cannot be parsed.
+func MustCompile(expr string) *Expr {
+	e, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// String returns the source text of the expression
+func (e *Expr) String() string {
+	return e.expr
+}
+
+// Evaluate evaluates the expression with t as the context node. The result is a bool, float64, string or, for a
+// node-set, a []interface{} in document order containing *Tag, *Attribute and the remaining Element types.
+func (e *Expr) Evaluate(t *Tag) (result interface{}, err error) {
+	defer recoverXPath(e.expr, &err)
+
+	ctx := newTagContext(t)
+	return exportXPathValue(e.root.eval(ctx)), nil
+}
+
+// Select evaluates the expression with t as the context node and returns the Tags of the resulting node-set in
+// document order. An error is returned if the expression does not evaluate to a node-set.
+func (e *Expr) Select(t *Tag) (s Search, err error) {
+	defer recoverXPath(e.expr, &err)
+	return e.selectContext(newTagContext(t))
+}
+
+// selectContext evaluates the expression and returns the Tags of the resulting node-set
+func (e *Expr) selectContext(ctx *xpathContext) (Search, error) {
+	nodes, ok := e.root.eval(ctx).(nodeSet)
+	if !ok {
+		return nil, &XPathError{Expr: e.expr, Offset: -1, Msg: "does not evaluate to a node-set"}
+	}
+
+	s := Search{}
+	for _, n := range nodes {
+		if n.kind == elementNode {
+			s = append(s, n.tag)
+		}
+	}
+	return s, nil
+}
+
+// Query compiles expr and returns the Tags it selects with the current Tag as the context node.
+func (t *Tag) Query(expr string) (Search, error) {
+	e, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return e.Select(t)
+}
+
+// QueryOne is like Query, but returns the first Tag in document order or nil if none are selected.
+func (t *Tag) QueryOne(expr string) (*Tag, error) {
+	s, err := t.Query(expr)
+	if err != nil {
+		return nil, err
+	}
+	return s.One(), nil
+}
+
+// Query compiles expr and returns the Tags it selects with the document root node as the context node.
+func (d *Document) Query(expr string) (s Search, err error) {
+	e, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	defer recoverXPath(expr, &err)
+	return e.selectContext(newDocumentContext(d))
+}
+
+// QueryOne is like Query, but returns the first Tag in document order or nil if none are selected.
+func (d *Document) QueryOne(expr string) (*Tag, error) {
+	s, err := d.Query(expr)
+	if err != nil {
+		return nil, err
+	}
+	return s.One(), nil
+}
+
+// XPathError is returned when an XPath expression can not be compiled or evaluated
+type XPathError struct {
+	// Expr is the source text of the expression
+	Expr string
+
+	// Offset is the byte offset in Expr at which a compile error was found, or -1 for evaluation errors
+	Offset int
+
+	// Msg describes the error
+	Msg string
+}
+
+// Error implements the error interface
+func (e *XPathError) Error() string {
+	if e.Offset >= 0 {
+		return fmt.Sprintf("xpath '%s' at offset %d: %s", e.Expr, e.Offset, e.Msg)
+	}
+	return fmt.Sprintf("xpath '%s': %s", e.Expr, e.Msg)
+}
+
+// recoverXPath converts a panic of *XPathError raised during evaluation into an error. Evaluation errors are
+// raised with panic to keep the recursive evaluator readable.
+func recoverXPath(expr string, err *error) {
+	if r := recover(); r != nil {
+		xe, ok := r.(*XPathError)
+		if !ok {
+			panic(r)
+		}
+		xe.Expr = expr
+		*err = xe
+	}
+}
+
+// xpathPanic raises an evaluation error
+func xpathPanic(format string, a ...interface{}) {
+	panic(&XPathError{Offset: -1, Msg: fmt.Sprintf(format, a...)})
+}
+
+/*
+	Nodes
+*/
+
+type nodeKind int
+
+const (
+	rootNode nodeKind = iota
+	elementNode
+	attributeNode
+	namespaceNode
+	textNode
+	commentNode
+	procInstNode
+)
+
+// xnode is a node of the XPath data model. tag is the element itself for element nodes and the owning element of
+// attribute and namespace nodes. el is set for text and comment nodes.
+type xnode struct {
+	kind nodeKind
+	tag  *Tag
+	attr *Attribute
+	el   Element
+}
+
+// nodeSet is a slice of xnode, kept in document order and free of duplicates by the evaluator
+type nodeSet []xnode
+
+// xpathIndex holds the parent relationships and document order of a tree. Tags know their parent, but the other
+// Elements (text, comments and processing instructions) do not, and the XPath data model needs both for every node.
+type xpathIndex struct {
+	top      []Element
+	parent   map[Element]*Tag
+	position map[xnode]int
+}
+
+// newXPathIndex walks the given top level elements in document order
+func newXPathIndex(top []Element) *xpathIndex {
+	idx := &xpathIndex{
+		top:      top,
+		parent:   map[Element]*Tag{},
+		position: map[xnode]int{},
+	}
+
+	idx.position[xnode{kind: rootNode}] = 0
+	for _, e := range top {
+		idx.add(e, nil)
+	}
+	return idx
+}
+
+// add records the document order and parent of an element and its descendants
+func (idx *xpathIndex) add(e Element, parent *Tag) {
+	idx.parent[e] = parent
+
+	n, ok := elementToNode(e)
+	if !ok {
+		return
+	}
+	idx.position[n] = len(idx.position)
+
+	if n.kind == elementNode {
+		for _, a := range n.tag.Attributes {
+			idx.position[xnode{kind: attributeNode, tag: n.tag, attr: a}] = len(idx.position)
+		}
+		for _, c := range n.tag.elements {
+			idx.add(c, n.tag)
+		}
+	}
+}
+
+// order returns the document order of a node. Namespace nodes share the order of their element.
+func (idx *xpathIndex) order(n xnode) int {
+	if n.kind == namespaceNode {
+		return idx.position[xnode{kind: elementNode, tag: n.tag}]
+	}
+	return idx.position[n]
+}
+
+// elementToNode returns the xnode for an Element, or false if the Element is not part of the XPath data model
+func elementToNode(e Element) (xnode, bool) {
+	switch v := e.(type) {
+	case *Tag:
+		return xnode{kind: elementNode, tag: v}, true
+	case *Value, *CDATA, *EntityRef, *Whitespace:
+		return xnode{kind: textNode, el: e}, true
+	case *Comment:
+		return xnode{kind: commentNode, el: e}, true
+	case *ProcInst:
+		return xnode{kind: procInstNode, el: e}, true
+	}
+	return xnode{}, false
+}
+
+// parentOf returns the parent node of n and false if n is the root node
+func (idx *xpathIndex) parentOf(n xnode) (xnode, bool) {
+	var p *Tag
+
+	switch n.kind {
+	case rootNode:
+		return xnode{}, false
+	case attributeNode, namespaceNode:
+		return xnode{kind: elementNode, tag: n.tag}, true
+	case elementNode:
+		p = idx.parent[n.tag]
+	default:
+		p = idx.parent[n.el]
+	}
+
+	if p == nil {
+		return xnode{kind: rootNode}, true
+	}
+	return xnode{kind: elementNode, tag: p}, true
+}
+
+// children returns the child nodes of n in document order
+func (idx *xpathIndex) children(n xnode) nodeSet {
+	var elements []Element
+
+	switch n.kind {
+	case rootNode:
+		elements = idx.top
+	case elementNode:
+		elements = n.tag.elements
+	}
+
+	var s nodeSet
+	for _, e := range elements {
+		if c, ok := elementToNode(e); ok {
+			s = append(s, c)
+		}
+	}
+	return s
+}
+
+// attributes returns the attribute nodes of an element node, excluding namespace declarations
+func (idx *xpathIndex) attributes(n xnode) nodeSet {
+	var s nodeSet
+	if n.kind == elementNode {
+		for _, a := range n.tag.Attributes {
+			if !a.IsNamespace() {
+				s = append(s, xnode{kind: attributeNode, tag: n.tag, attr: a})
+			}
+		}
+	}
+	return s
+}
+
+// namespaces returns a namespace node for each namespace declaration in scope of an element node
+func (idx *xpathIndex) namespaces(n xnode) nodeSet {
+	var s nodeSet
+	if n.kind != elementNode {
+		return s
+	}
+
+	seen := map[string]bool{}
+	for t := n.tag; t != nil; t = idx.parent[t] {
+		for _, a := range t.Attributes {
+			if a.IsNamespace() && !seen[a.declaredPrefix()] {
+				seen[a.declaredPrefix()] = true
+				s = append(s, xnode{kind: namespaceNode, tag: n.tag, attr: a})
+			}
+		}
+	}
+	return s
+}
+
+// stringValue returns the XPath string-value of a node
+func (idx *xpathIndex) stringValue(n xnode) string {
+	switch n.kind {
+	case attributeNode, namespaceNode:
+		return n.attr.Value
+	case textNode, commentNode, procInstNode:
+		v, _ := n.el.Value()
+		return v
+	}
+
+	var b strings.Builder
+	var walk func(xnode)
+	walk = func(n xnode) {
+		for _, c := range idx.children(n) {
+			switch c.kind {
+			case textNode:
+				v, _ := c.el.Value()
+				b.WriteString(v)
+			case elementNode:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// namespaceURI returns the namespace of an element or attribute node, or an empty string if there is none
+func (idx *xpathIndex) namespaceURI(n xnode) string {
+	switch n.kind {
+	case elementNode:
+		ns, _ := n.tag.NamespaceURI()
+		return ns
+	case attributeNode:
+		if n.attr.Prefix != "" {
+			ns, _ := n.tag.GetNamespace(n.attr.Prefix)
+			return ns
+		}
+	}
+	return ""
+}
+
+// localName returns the local part of the name of a node
+func localName(n xnode) string {
+	switch n.kind {
+	case elementNode:
+		return n.tag.Name
+	case attributeNode:
+		return n.attr.Name
+	case namespaceNode:
+		return n.attr.declaredPrefix()
+	case procInstNode:
+		return n.el.(*ProcInst).Target
+	}
+	return ""
+}
+
+// qualifiedNodeName returns the name of a node including its prefix
+func qualifiedNodeName(n xnode) string {
+	switch n.kind {
+	case elementNode:
+		return n.tag.qualifiedName()
+	case attributeNode:
+		if n.attr.Prefix != "" {
+			return n.attr.Prefix + ":" + n.attr.Name
+		}
+		return n.attr.Name
+	case namespaceNode:
+		return n.attr.declaredPrefix()
+	case procInstNode:
+		return n.el.(*ProcInst).Target
+	}
+	return ""
+}
+
+// sortNodes sorts a node-set into document order and removes duplicates
+func (idx *xpathIndex) sortNodes(s nodeSet) nodeSet {
+	seen := map[xnode]bool{}
+	var r nodeSet
+	for _, n := range s {
+		if !seen[n] {
+			seen[n] = true
+			r = append(r, n)
+		}
+	}
+
+	sort.SliceStable(r, func(i, j int) bool {
+		return idx.order(r[i]) < idx.order(r[j])
+	})
+	return r
+}
+
+/*
+	Context
+*/
+
+// xpathContext is the evaluation context of an expression
+type xpathContext struct {
+	idx *xpathIndex
+
+	// node is the context node, position and size the context position and size
+	node     xnode
+	position int
+	size     int
+
+	// ns is the Tag used to resolve the prefixes of name tests
+	ns *Tag
+}
+
+// newTagContext returns a context with t as the context node. The tree is indexed from the Document of t, or its top
+// most parent if it is not part of a Document.
+func newTagContext(t *Tag) *xpathContext {
+	top := []Element{t}
+	if ancestors := t.Ancestors(); len(ancestors) > 0 {
+		top = []Element{ancestors[len(ancestors)-1]}
+	}
+	if d := t.Document(); d != nil {
+		top = d.elements
+	}
+
+	return &xpathContext{
+		idx:      newXPathIndex(top),
+		node:     xnode{kind: elementNode, tag: t},
+		position: 1,
+		size:     1,
+		ns:       t,
+	}
+}
+
+// newDocumentContext returns a context with the root node of d as the context node
+func newDocumentContext(d *Document) *xpathContext {
+	ctx := &xpathContext{
+		idx:      newXPathIndex(d.elements),
+		node:     xnode{kind: rootNode},
+		position: 1,
+		size:     1,
+	}
+
+	for _, e := range d.elements {
+		if t, ok := e.(*Tag); ok {
+			ctx.ns = t
+			break
+		}
+	}
+	return ctx
+}
+
+// with returns a copy of the context for the given node, position and size
+func (ctx *xpathContext) with(n xnode, position, size int) *xpathContext {
+	c := *ctx
+	c.node = n
+	c.position = position
+	c.size = size
+	return &c
+}
+
+// resolvePrefix returns the namespace for a prefix used in the expression
+func (ctx *xpathContext) resolvePrefix(prefix string) (string, bool) {
+	if ctx.ns == nil {
+		return "", false
+	}
+	ns, err := ctx.ns.GetNamespace(prefix)
+	return ns, err == nil
+}
+
+// exportXPathValue converts an internal value into the result of Evaluate
+func exportXPathValue(v interface{}) interface{} {
+	s, ok := v.(nodeSet)
+	if !ok {
+		return v
+	}
+
+	r := []interface{}{}
+	for _, n := range s {
+		switch n.kind {
+		case elementNode:
+			r = append(r, n.tag)
+		case attributeNode, namespaceNode:
+			r = append(r, n.attr)
+		case textNode, commentNode, procInstNode:
+			r = append(r, n.el)
+		}
+	}
+	return r
+}
+
+/*
+	Lexer
+*/
+
+type xpathTokenKind int
+
+const (
+	tokenEOF xpathTokenKind = iota
+	tokenNumber
+	tokenLiteral
+	tokenName     // an NCName or QName, including 'prefix:*'
+	tokenOperator // and, or, mod, div, *, /, //, |, +, -, =, !=, <, <=, >, >=
+	tokenSymbol   // (, ), [, ], ., .., @, ,, ::, $
+	tokenStar     // a '*' name test
+)
+
+type xpathToken struct {
+	kind   xpathTokenKind
+	value  string
+	offset int
+}
+
+// lexXPath splits an expression into tokens, disambiguating '*' and operator names per the XPath 1.0 lexical rules
+func lexXPath(expr string) ([]xpathToken, error) {
+	var tokens []xpathToken
+
+	// operatorAllowed reports whether the previous token allows the next '*' or name to be an operator
+	operatorAllowed := func() bool {
+		if len(tokens) == 0 {
+			return false
+		}
+		p := tokens[len(tokens)-1]
+		switch p.kind {
+		case tokenOperator:
+			return false
+		case tokenSymbol:
+			return p.value == ")" || p.value == "]" || p.value == "." || p.value == ".."
+		}
+		return true
+	}
+
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, &XPathError{expr, start, "unterminated string literal"}
+			}
+			tokens = append(tokens, xpathToken{tokenLiteral, expr[i+1 : i+1+end], start})
+			i += end + 2
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(expr) && expr[i+1] >= '0' && expr[i+1] <= '9':
+			for i < len(expr) && (expr[i] >= '0' && expr[i] <= '9' || expr[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, xpathToken{tokenNumber, expr[start:i], start})
+		case c == '.':
+			if strings.HasPrefix(expr[i:], "..") {
+				i += 2
+			} else {
+				i++
+			}
+			tokens = append(tokens, xpathToken{tokenSymbol, expr[start:i], start})
+		case c == '/':
+			if strings.HasPrefix(expr[i:], "//") {
+				i += 2
+			} else {
+				i++
+			}
+			tokens = append(tokens, xpathToken{tokenOperator, expr[start:i], start})
+		case c == ':' && strings.HasPrefix(expr[i:], "::"):
+			i += 2
+			tokens = append(tokens, xpathToken{tokenSymbol, "::", start})
+		case strings.IndexByte("()[]@,$", c) >= 0:
+			i++
+			tokens = append(tokens, xpathToken{tokenSymbol, string(c), start})
+		case c == '!' && strings.HasPrefix(expr[i:], "!="), c == '<' && strings.HasPrefix(expr[i:], "<="),
+			c == '>' && strings.HasPrefix(expr[i:], ">="):
+			i += 2
+			tokens = append(tokens, xpathToken{tokenOperator, expr[start:i], start})
+		case strings.IndexByte("|+-=<>", c) >= 0:
+			i++
+			tokens = append(tokens, xpathToken{tokenOperator, string(c), start})
+		case c == '*':
+			i++
+			if operatorAllowed() {
+				tokens = append(tokens, xpathToken{tokenOperator, "*", start})
+			} else {
+				tokens = append(tokens, xpathToken{tokenStar, "*", start})
+			}
+		default:
+			r, _ := utf8.DecodeRuneInString(expr[i:])
+			if !isNameStart(r) {
+				return nil, &XPathError{expr, start, fmt.Sprintf("unexpected character '%c'", r)}
+			}
+
+			i = scanNCName(expr, i)
+			name := expr[start:i]
+
+			if operatorAllowed() && (name == "and" || name == "or" || name == "mod" || name == "div") {
+				tokens = append(tokens, xpathToken{tokenOperator, name, start})
+				continue
+			}
+
+			// a single ':' makes a QName, 'prefix:*' or 'prefix:local'
+			if i+1 < len(expr) && expr[i] == ':' && expr[i+1] != ':' {
+				if expr[i+1] == '*' {
+					i += 2
+				} else if r, _ := utf8.DecodeRuneInString(expr[i+1:]); isNameStart(r) {
+					i = scanNCName(expr, i+1)
+				}
+			}
+			tokens = append(tokens, xpathToken{tokenName, expr[start:i], start})
+		}
+	}
+
+	return append(tokens, xpathToken{tokenEOF, "", len(expr)}), nil
+}
+
+// isNameStart returns true if r may start an NCName
+func isNameStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+// isNameChar returns true if r may be part of an NCName
+func isNameChar(r rune) bool {
+	return isNameStart(r) || r == '-' || r == '.' || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// scanNCName returns the offset of the end of the NCName starting at i
+func scanNCName(s string, i int) int {
+	for i < len(s) {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if !isNameChar(r) {
+			break
+		}
+		i += n
+	}
+	return i
+}
+
+/*
+	Parser
+*/
+
+type xpathParser struct {
+	expr   string
+	tokens []xpathToken
+	pos    int
+}
+
+func (p *xpathParser) peek() xpathToken {
+	return p.tokens[p.pos]
+}
+
+func (p *xpathParser) peekAt(n int) xpathToken {
+	if p.pos+n < len(p.tokens) {
+		return p.tokens[p.pos+n]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *xpathParser) next() xpathToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is returns true if the next token is of the given kind and value
+func (p *xpathParser) is(kind xpathTokenKind, value string) bool {
+	t := p.peek()
+	return t.kind == kind && t.value == value
+}
+
+func (p *xpathParser) errorf(t xpathToken, format string, a ...interface{}) error {
+	return &XPathError{p.expr, t.offset, fmt.Sprintf(format, a...)}
+}
+
+func (p *xpathParser) expect(kind xpathTokenKind, value string) error {
+	if t := p.next(); t.kind != kind || t.value != value {
+		return p.errorf(t, "expected '%s'", value)
+	}
+	return nil
+}
+
+func (p *xpathParser) parse() (xpathExpr, error) {
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected '%s'", t.value)
+	}
+	return e, nil
+}
+
+// parseBinary parses a left associative binary expression of the given operators
+func (p *xpathParser) parseBinary(operand func() (xpathExpr, error), ops ...string) (xpathExpr, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		matched := false
+		for _, op := range ops {
+			if t.kind == tokenOperator && t.value == op {
+				matched = true
+			}
+		}
+		if !matched {
+			return l, nil
+		}
+
+		p.next()
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: t.value, l: l, r: r}
+	}
+}
+
+func (p *xpathParser) parseOr() (xpathExpr, error) {
+	return p.parseBinary(p.parseAnd, "or")
+}
+
+func (p *xpathParser) parseAnd() (xpathExpr, error) {
+	return p.parseBinary(p.parseEquality, "and")
+}
+
+func (p *xpathParser) parseEquality() (xpathExpr, error) {
+	return p.parseBinary(p.parseRelational, "=", "!=")
+}
+
+func (p *xpathParser) parseRelational() (xpathExpr, error) {
+	return p.parseBinary(p.parseAdditive, "<", "<=", ">", ">=")
+}
+
+func (p *xpathParser) parseAdditive() (xpathExpr, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *xpathParser) parseMultiplicative() (xpathExpr, error) {
+	return p.parseBinary(p.parseUnary, "*", "div", "mod")
+}
+
+func (p *xpathParser) parseUnary() (xpathExpr, error) {
+	if p.is(tokenOperator, "-") {
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negateExpr{e}, nil
+	}
+	return p.parseUnion()
+}
+
+func (p *xpathParser) parseUnion() (xpathExpr, error) {
+	return p.parseBinary(p.parsePath, "|")
+}
+
+// parsePath parses a LocationPath or a FilterExpr optionally followed by a RelativeLocationPath
+func (p *xpathParser) parsePath() (xpathExpr, error) {
+	if !p.startsFilter() {
+		return p.parseLocationPath()
+	}
+
+	filter, err := p.parseFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.is(tokenOperator, "/") && !p.is(tokenOperator, "//") {
+		return filter, nil
+	}
+
+	path := &pathExpr{filter: filter}
+	if err := p.parseRelativePath(path); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// startsFilter returns true if the next token begins a FilterExpr rather than a LocationPath
+func (p *xpathParser) startsFilter() bool {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber, tokenLiteral:
+		return true
+	case tokenSymbol:
+		return t.value == "(" || t.value == "$"
+	case tokenName:
+		next := p.peekAt(1)
+		return next.kind == tokenSymbol && next.value == "(" && !isNodeType(t.value)
+	}
+	return false
+}
+
+func isNodeType(name string) bool {
+	return name == "node" || name == "text" || name == "comment" || name == "processing-instruction"
+}
+
+func (p *xpathParser) parseFilter() (xpathExpr, error) {
+	var primary xpathExpr
+	t := p.next()
+
+	switch {
+	case t.kind == tokenNumber:
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number '%s'", t.value)
+		}
+		primary = numberExpr(f)
+	case t.kind == tokenLiteral:
+		primary = literalExpr(t.value)
+	case t.kind == tokenSymbol && t.value == "$":
+		name := p.next()
+		if name.kind != tokenName {
+			return nil, p.errorf(name, "expected variable name")
+		}
+		primary = &variableExpr{name.value}
+	case t.kind == tokenSymbol && t.value == "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenSymbol, ")"); err != nil {
+			return nil, err
+		}
+		primary = e
+	default:
+		f, err := p.parseFunction(t)
+		if err != nil {
+			return nil, err
+		}
+		primary = f
+	}
+
+	preds, err := p.parsePredicates()
+	if err != nil {
+		return nil, err
+	}
+	if len(preds) == 0 {
+		return primary, nil
+	}
+	return &filterExpr{primary: primary, predicates: preds}, nil
+}
+
+func (p *xpathParser) parseFunction(name xpathToken) (xpathExpr, error) {
+	fn, ok := xpathFunctions[name.value]
+	if !ok {
+		return nil, p.errorf(name, "unknown function '%s'", name.value)
+	}
+
+	p.next() // (
+	f := &functionExpr{name: name.value, fn: fn}
+	for !p.is(tokenSymbol, ")") {
+		if len(f.args) > 0 {
+			if err := p.expect(tokenSymbol, ","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		f.args = append(f.args, arg)
+	}
+	p.next() // )
+
+	if len(f.args) < fn.min || fn.max >= 0 && len(f.args) > fn.max {
+		return nil, p.errorf(name, "wrong number of arguments to '%s'", name.value)
+	}
+	return f, nil
+}
+
+func (p *xpathParser) parsePredicates() ([]xpathExpr, error) {
+	var preds []xpathExpr
+	for p.is(tokenSymbol, "[") {
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenSymbol, "]"); err != nil {
+			return nil, err
+		}
+		preds = append(preds, e)
+	}
+	return preds, nil
+}
+
+func (p *xpathParser) parseLocationPath() (xpathExpr, error) {
+	path := &pathExpr{}
+
+	if p.is(tokenOperator, "/") {
+		path.absolute = true
+		p.next()
+		if !p.startsStep() {
+			return path, nil
+		}
+	} else if p.is(tokenOperator, "//") {
+		path.absolute = true
+		p.next()
+		path.steps = append(path.steps, descendantOrSelfStep())
+	}
+
+	step, err := p.parseStep()
+	if err != nil {
+		return nil, err
+	}
+	path.steps = append(path.steps, step)
+
+	if err := p.parseRelativePath(path); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// parseRelativePath appends each '/' or '//' separated step to path
+func (p *xpathParser) parseRelativePath(path *pathExpr) error {
+	for p.is(tokenOperator, "/") || p.is(tokenOperator, "//") {
+		if p.next().value == "//" {
+			path.steps = append(path.steps, descendantOrSelfStep())
+		}
+		step, err := p.parseStep()
+		if err != nil {
+			return err
+		}
+		path.steps = append(path.steps, step)
+	}
+	return nil
+}
+
+// startsStep returns true if the next token begins a location step
+func (p *xpathParser) startsStep() bool {
+	t := p.peek()
+	switch t.kind {
+	case tokenName, tokenStar:
+		return true
+	case tokenSymbol:
+		return t.value == "." || t.value == ".." || t.value == "@"
+	}
+	return false
+}
+
+func descendantOrSelfStep() *xpathStep {
+	return &xpathStep{axis: axisDescendantOrSelf, test: nodeTest{kind: testNode}}
+}
+
+func (p *xpathParser) parseStep() (*xpathStep, error) {
+	t := p.peek()
+
+	// abbreviated steps
+	if p.is(tokenSymbol, ".") {
+		p.next()
+		return &xpathStep{axis: axisSelf, test: nodeTest{kind: testNode}}, nil
+	} else if p.is(tokenSymbol, "..") {
+		p.next()
+		return &xpathStep{axis: axisParent, test: nodeTest{kind: testNode}}, nil
+	}
+
+	step := &xpathStep{axis: axisChild}
+	if p.is(tokenSymbol, "@") {
+		p.next()
+		step.axis = axisAttribute
+	} else if t.kind == tokenName && p.peekAt(1).kind == tokenSymbol && p.peekAt(1).value == "::" {
+		axis, ok := xpathAxes[t.value]
+		if !ok {
+			return nil, p.errorf(t, "unknown axis '%s'", t.value)
+		}
+		step.axis = axis
+		p.next()
+		p.next()
+	}
+
+	test, err := p.parseNodeTest()
+	if err != nil {
+		return nil, err
+	}
+	step.test = test
+
+	preds, err := p.parsePredicates()
+	if err != nil {
+		return nil, err
+	}
+	step.predicates = preds
+	return step, nil
+}
+
+func (p *xpathParser) parseNodeTest() (nodeTest, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenStar:
+		return nodeTest{kind: testName, local: "*"}, nil
+	case tokenName:
+		if isNodeType(t.value) && p.is(tokenSymbol, "(") {
+			p.next()
+			test := nodeTest{kind: testNode}
+			switch t.value {
+			case "text":
+				test.kind = testText
+			case "comment":
+				test.kind = testComment
+			case "processing-instruction":
+				test.kind = testProcInst
+				if p.peek().kind == tokenLiteral {
+					test.local = p.next().value
+				}
+			}
+			return test, p.expect(tokenSymbol, ")")
+		}
+
+		test := nodeTest{kind: testName, local: t.value}
+		if i := strings.IndexByte(t.value, ':'); i >= 0 {
+			test.prefix = t.value[:i]
+			test.local = t.value[i+1:]
+		}
+		return test, nil
+	}
+
+	return nodeTest{}, p.errorf(t, "expected node test")
+}
+
+/*
+	Expressions
+*/
+
+// xpathExpr is a node of a parsed expression. eval returns a bool, float64, string or nodeSet.
+type xpathExpr interface {
+	eval(ctx *xpathContext) interface{}
+}
+
+type numberExpr float64
+
+func (e numberExpr) eval(ctx *xpathContext) interface{} {
+	return float64(e)
+}
+
+type literalExpr string
+
+func (e literalExpr) eval(ctx *xpathContext) interface{} {
+	return string(e)
+}
+
+type variableExpr struct {
+	name string
+}
+
+func (e *variableExpr) eval(ctx *xpathContext) interface{} {
+	xpathPanic("variable '$%s' is not bound", e.name)
+	return nil
+}
+
+type negateExpr struct {
+	e xpathExpr
+}
+
+func (e *negateExpr) eval(ctx *xpathContext) interface{} {
+	return -ctx.number(e.e.eval(ctx))
+}
+
+type binaryExpr struct {
+	op   string
+	l, r xpathExpr
+}
+
+func (e *binaryExpr) eval(ctx *xpathContext) interface{} {
+	switch e.op {
+	case "or":
+		return ctx.boolean(e.l.eval(ctx)) || ctx.boolean(e.r.eval(ctx))
+	case "and":
+		return ctx.boolean(e.l.eval(ctx)) && ctx.boolean(e.r.eval(ctx))
+	case "|":
+		l, lok := e.l.eval(ctx).(nodeSet)
+		r, rok := e.r.eval(ctx).(nodeSet)
+		if !lok || !rok {
+			xpathPanic("union of non node-set")
+		}
+		return ctx.idx.sortNodes(append(append(nodeSet{}, l...), r...))
+	case "=", "!=", "<", "<=", ">", ">=":
+		return ctx.compare(e.op, e.l.eval(ctx), e.r.eval(ctx))
+	}
+
+	l := ctx.number(e.l.eval(ctx))
+	r := ctx.number(e.r.eval(ctx))
+	switch e.op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "div":
+		return l / r
+	}
+	return math.Mod(l, r)
+}
+
+type filterExpr struct {
+	primary    xpathExpr
+	predicates []xpathExpr
+}
+
+func (e *filterExpr) eval(ctx *xpathContext) interface{} {
+	s, ok := e.primary.eval(ctx).(nodeSet)
+	if !ok {
+		xpathPanic("predicate applied to non node-set")
+	}
+	for _, pred := range e.predicates {
+		s = ctx.filter(s, pred)
+	}
+	return s
+}
+
+type functionExpr struct {
+	name string
+	fn   xpathFunction
+	args []xpathExpr
+}
+
+func (e *functionExpr) eval(ctx *xpathContext) interface{} {
+	return e.fn.call(ctx, e.args)
+}
+
+// pathExpr is a location path, optionally relative to the result of a filter expression
+type pathExpr struct {
+	filter   xpathExpr
+	absolute bool
+	steps    []*xpathStep
+}
+
+func (e *pathExpr) eval(ctx *xpathContext) interface{} {
+	var s nodeSet
+
+	switch {
+	case e.filter != nil:
+		var ok bool
+		if s, ok = e.filter.eval(ctx).(nodeSet); !ok {
+			xpathPanic("path applied to non node-set")
+		}
+	case e.absolute:
+		s = nodeSet{{kind: rootNode}}
+	default:
+		s = nodeSet{ctx.node}
+	}
+
+	for _, step := range e.steps {
+		s = step.eval(ctx, s)
+	}
+	return s
+}
+
+/*
+	Steps
+*/
+
+type xpathAxis int
+
+const (
+	axisAncestor xpathAxis = iota
+	axisAncestorOrSelf
+	axisAttribute
+	axisChild
+	axisDescendant
+	axisDescendantOrSelf
+	axisFollowing
+	axisFollowingSibling
+	axisNamespace
+	axisParent
+	axisPreceding
+	axisPrecedingSibling
+	axisSelf
+)
+
+var xpathAxes = map[string]xpathAxis{
+	"ancestor":           axisAncestor,
+	"ancestor-or-self":   axisAncestorOrSelf,
+	"attribute":          axisAttribute,
+	"child":              axisChild,
+	"descendant":         axisDescendant,
+	"descendant-or-self": axisDescendantOrSelf,
+	"following":          axisFollowing,
+	"following-sibling":  axisFollowingSibling,
+	"namespace":          axisNamespace,
+	"parent":             axisParent,
+	"preceding":          axisPreceding,
+	"preceding-sibling":  axisPrecedingSibling,
+	"self":               axisSelf,
+}
+
+// reverse returns true for the axes whose proximity positions are in reverse document order
+func (a xpathAxis) reverse() bool {
+	return a == axisAncestor || a == axisAncestorOrSelf || a == axisPreceding || a == axisPrecedingSibling
+}
+
+type testKind int
+
+const (
+	testName testKind = iota
+	testNode
+	testText
+	testComment
+	testProcInst
+)
+
+// nodeTest is a name test (local may be '*') or a node type test
+type nodeTest struct {
+	kind   testKind
+	prefix string
+	local  string
+}
+
+type xpathStep struct {
+	axis       xpathAxis
+	test       nodeTest
+	predicates []xpathExpr
+}
+
+// eval applies the step to each node of the input node-set and returns the union of the results
+func (s *xpathStep) eval(ctx *xpathContext, input nodeSet) nodeSet {
+	var r nodeSet
+
+	for _, n := range input {
+		var matched nodeSet
+		for _, c := range ctx.axis(s.axis, n) {
+			if ctx.matches(s.axis, s.test, c) {
+				matched = append(matched, c)
+			}
+		}
+
+		for _, pred := range s.predicates {
+			matched = ctx.filter(matched, pred)
+		}
+		r = append(r, matched...)
+	}
+
+	return ctx.idx.sortNodes(r)
+}
+
+// axis returns the nodes of an axis from n in proximity order
+func (ctx *xpathContext) axis(axis xpathAxis, n xnode) nodeSet {
+	idx := ctx.idx
+	var s nodeSet
+
+	switch axis {
+	case axisSelf:
+		s = nodeSet{n}
+	case axisChild:
+		s = idx.children(n)
+	case axisAttribute:
+		s = idx.attributes(n)
+	case axisNamespace:
+		s = idx.namespaces(n)
+	case axisParent:
+		if p, ok := idx.parentOf(n); ok {
+			s = nodeSet{p}
+		}
+	case axisAncestor, axisAncestorOrSelf:
+		if axis == axisAncestorOrSelf {
+			s = append(s, n)
+		}
+		for p, ok := idx.parentOf(n); ok; p, ok = idx.parentOf(p) {
+			s = append(s, p)
+		}
+	case axisDescendant, axisDescendantOrSelf:
+		if axis == axisDescendantOrSelf {
+			s = append(s, n)
+		}
+		s = append(s, ctx.descendants(n)...)
+	case axisFollowingSibling, axisPrecedingSibling:
+		if n.kind == attributeNode || n.kind == namespaceNode {
+			break
+		}
+		p, ok := idx.parentOf(n)
+		if !ok {
+			break
+		}
+		siblings := idx.children(p)
+		for i, c := range siblings {
+			if c != n {
+				continue
+			}
+			if axis == axisFollowingSibling {
+				s = append(s, siblings[i+1:]...)
+			} else {
+				for j := i - 1; j >= 0; j-- {
+					s = append(s, siblings[j])
+				}
+			}
+			break
+		}
+	case axisFollowing, axisPreceding:
+		// following and preceding exclude descendants and ancestors, attribute and namespace nodes begin at their
+		// element
+		start := n
+		if n.kind == attributeNode || n.kind == namespaceNode {
+			start = xnode{kind: elementNode, tag: n.tag}
+		}
+
+		excluded := map[xnode]bool{}
+		for p, ok := idx.parentOf(start); ok; p, ok = idx.parentOf(p) {
+			excluded[p] = true
+		}
+		for _, d := range ctx.descendants(start) {
+			excluded[d] = true
+		}
+
+		all := ctx.descendants(xnode{kind: rootNode})
+		order := idx.order(start)
+		if axis == axisFollowing {
+			for _, c := range all {
+				if idx.order(c) > order && !excluded[c] {
+					s = append(s, c)
+				}
+			}
+		} else {
+			for i := len(all) - 1; i >= 0; i-- {
+				if c := all[i]; idx.order(c) < order && !excluded[c] {
+					s = append(s, c)
+				}
+			}
+		}
+	}
+
+	return s
+}
+
+// descendants returns the descendants of n in document order
+func (ctx *xpathContext) descendants(n xnode) nodeSet {
+	var s nodeSet
+	for _, c := range ctx.idx.children(n) {
+		s = append(s, c)
+		s = append(s, ctx.descendants(c)...)
+	}
+	return s
+}
+
+// matches returns true if n passes the node test for the given axis
+func (ctx *xpathContext) matches(axis xpathAxis, test nodeTest, n xnode) bool {
+	switch test.kind {
+	case testNode:
+		return true
+	case testText:
+		return n.kind == textNode
+	case testComment:
+		return n.kind == commentNode
+	case testProcInst:
+		return n.kind == procInstNode && (test.local == "" || n.el.(*ProcInst).Target == test.local)
+	}
+
+	// name tests only match the principal node type of the axis
+	principal := elementNode
+	if axis == axisAttribute {
+		principal = attributeNode
+	} else if axis == axisNamespace {
+		principal = namespaceNode
+	}
+	if n.kind != principal {
+		return false
+	}
+
+	if test.local != "*" && test.local != localName(n) {
+		return false
+	}
+	if test.prefix == "" || n.kind == namespaceNode {
+		return true
+	}
+
+	if ns, ok := ctx.resolvePrefix(test.prefix); ok {
+		return ctx.idx.namespaceURI(n) == ns
+	}
+
+	if n.kind == elementNode {
+		return n.tag.Prefix == test.prefix
+	}
+	return n.attr.Prefix == test.prefix
+}
+
+// filter returns the nodes of s for which the predicate is true, using the order of s for proximity positions
+func (ctx *xpathContext) filter(s nodeSet, pred xpathExpr) nodeSet {
+	var r nodeSet
+	for i, n := range s {
+		v := pred.eval(ctx.with(n, i+1, len(s)))
+		if f, ok := v.(float64); ok {
+			if f == float64(i+1) {
+				r = append(r, n)
+			}
+		} else if ctx.boolean(v) {
+			r = append(r, n)
+		}
+	}
+	return r
+}
+
+/*
+	Conversions
+*/
+
+func (ctx *xpathContext) boolean(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case float64:
+		return t != 0 && !math.IsNaN(t)
+	case string:
+		return t != ""
+	case nodeSet:
+		return len(t) > 0
+	}
+	return false
+}
+
+func (ctx *xpathContext) number(v interface{}) float64 {
+	switch t := v.(type) {
+	case bool:
+		if t {
+			return 1
+		}
+		return 0
+	case float64:
+		return t
+	case string:
+		return stringToNumber(t)
+	case nodeSet:
+		return stringToNumber(ctx.string(t))
+	}
+	return math.NaN()
+}
+
+func (ctx *xpathContext) string(v interface{}) string {
+	switch t := v.(type) {
+	case bool:
+		if t {
+			return "true"
+		}
+		return "false"
+	case float64:
+		return numberToString(t)
+	case string:
+		return t
+	case nodeSet:
+		if len(t) == 0 {
+			return ""
+		}
+		return ctx.idx.stringValue(t[0])
+	}
+	return ""
+}
+
+// stringToNumber converts a string to a number following the XPath Number production, returning NaN otherwise
+func stringToNumber(s string) float64 {
+	s = strings.TrimSpace(s)
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || strings.Trim(digits, "0123456789.") != "" || strings.Count(digits, ".") > 1 || digits == "." {
+		return math.NaN()
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+// numberToString converts a number to a string as defined by the XPath string() function
+func numberToString(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		return "0"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// compare implements the XPath equality and relational operators
+func (ctx *xpathContext) compare(op string, l, r interface{}) bool {
+	ls, lok := l.(nodeSet)
+	rs, rok := r.(nodeSet)
+
+	switch {
+	case lok && rok:
+		for _, a := range ls {
+			for _, b := range rs {
+				if ctx.compareAtomic(op, ctx.idx.stringValue(a), ctx.idx.stringValue(b)) {
+					return true
+				}
+			}
+		}
+		return false
+	case lok:
+		return ctx.compareNodeSet(op, ls, r, false)
+	case rok:
+		return ctx.compareNodeSet(op, rs, l, true)
+	}
+	return ctx.compareAtomic(op, l, r)
+}
+
+// compareNodeSet compares each node of s with an atomic value, swapping the operands when s was on the right
+func (ctx *xpathContext) compareNodeSet(op string, s nodeSet, v interface{}, swapped bool) bool {
+	if b, ok := v.(bool); ok {
+		if swapped {
+			return ctx.compareAtomic(op, b, ctx.boolean(s))
+		}
+		return ctx.compareAtomic(op, ctx.boolean(s), b)
+	}
+
+	for _, n := range s {
+		var nv interface{} = ctx.idx.stringValue(n)
+		if _, ok := v.(float64); ok {
+			nv = stringToNumber(nv.(string))
+		}
+
+		var r bool
+		if swapped {
+			r = ctx.compareAtomic(op, v, nv)
+		} else {
+			r = ctx.compareAtomic(op, nv, v)
+		}
+		if r {
+			return true
+		}
+	}
+	return false
+}
+
+// compareAtomic compares two non node-set values
+func (ctx *xpathContext) compareAtomic(op string, l, r interface{}) bool {
+	if op == "=" || op == "!=" {
+		var eq bool
+		_, lb := l.(bool)
+		_, rb := r.(bool)
+		_, lf := l.(float64)
+		_, rf := r.(float64)
+
+		switch {
+		case lb || rb:
+			eq = ctx.boolean(l) == ctx.boolean(r)
+		case lf || rf:
+			eq = ctx.number(l) == ctx.number(r)
+		default:
+			eq = ctx.string(l) == ctx.string(r)
+		}
+
+		if op == "=" {
+			return eq
+		}
+		return !eq
+	}
+
+	a, b := ctx.number(l), ctx.number(r)
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	}
+	return a >= b
+}
+
+/*
+	Functions
+*/
+
+// xpathFunction is a function of the core library. max is -1 for a variable number of arguments.
+type xpathFunction struct {
+	min, max int
+	call     func(ctx *xpathContext, args []xpathExpr) interface{}
+}
+
+var xpathFunctions map[string]xpathFunction
+
+func init() {
+	xpathFunctions = map[string]xpathFunction{
+		// node-set functions
+		"last":     {0, 0, func(ctx *xpathContext, args []xpathExpr) interface{} { return float64(ctx.size) }},
+		"position": {0, 0, func(ctx *xpathContext, args []xpathExpr) interface{} { return float64(ctx.position) }},
+		"count": {1, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return float64(len(ctx.nodeSetArg(args[0])))
+		}},
+		"id":            {1, 1, xpathID},
+		"local-name":    {0, 1, nodeNameFunction(func(ctx *xpathContext, n xnode) string { return localName(n) })},
+		"namespace-uri": {0, 1, nodeNameFunction(func(ctx *xpathContext, n xnode) string { return ctx.idx.namespaceURI(n) })},
+		"name":          {0, 1, nodeNameFunction(func(ctx *xpathContext, n xnode) string { return qualifiedNodeName(n) })},
+
+		// string functions
+		"string": {0, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return ctx.stringArg(args, 0)
+		}},
+		"concat": {2, -1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			var b strings.Builder
+			for i := range args {
+				b.WriteString(ctx.stringArg(args, i))
+			}
+			return b.String()
+		}},
+		"starts-with": {2, 2, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return strings.HasPrefix(ctx.stringArg(args, 0), ctx.stringArg(args, 1))
+		}},
+		"contains": {2, 2, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return strings.Contains(ctx.stringArg(args, 0), ctx.stringArg(args, 1))
+		}},
+		"substring-before": {2, 2, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			s, sep := ctx.stringArg(args, 0), ctx.stringArg(args, 1)
+			if i := strings.Index(s, sep); i >= 0 {
+				return s[:i]
+			}
+			return ""
+		}},
+		"substring-after": {2, 2, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			s, sep := ctx.stringArg(args, 0), ctx.stringArg(args, 1)
+			if i := strings.Index(s, sep); i >= 0 {
+				return s[i+len(sep):]
+			}
+			return ""
+		}},
+		"substring": {2, 3, xpathSubstring},
+		"string-length": {0, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return float64(utf8.RuneCountInString(ctx.stringArg(args, 0)))
+		}},
+		"normalize-space": {0, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return strings.Join(strings.Fields(ctx.stringArg(args, 0)), " ")
+		}},
+		"translate": {3, 3, xpathTranslate},
+
+		// extensions for matching names that differ in case or spelling, lower-case, upper-case and matches are
+		// borrowed from XPath 2.0
+		"lower-case": {1, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return strings.ToLower(ctx.stringArg(args, 0))
+		}},
+		"upper-case": {1, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return strings.ToUpper(ctx.stringArg(args, 0))
+		}},
+		"matches": {2, 3, xpathMatches},
+		"glob": {2, 2, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return matchGlob(ctx.stringArg(args, 1), ctx.stringArg(args, 0))
+		}},
+
+		// boolean functions
+		"boolean": {1, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return ctx.boolean(args[0].eval(ctx))
+		}},
+		"not": {1, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return !ctx.boolean(args[0].eval(ctx))
+		}},
+		"true":  {0, 0, func(ctx *xpathContext, args []xpathExpr) interface{} { return true }},
+		"false": {0, 0, func(ctx *xpathContext, args []xpathExpr) interface{} { return false }},
+		"lang":  {1, 1, xpathLang},
+
+		// number functions
+		"number": {0, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			if len(args) == 0 {
+				return ctx.number(nodeSet{ctx.node})
+			}
+			return ctx.number(args[0].eval(ctx))
+		}},
+		"sum": {1, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			var sum float64
+			for _, n := range ctx.nodeSetArg(args[0]) {
+				sum += stringToNumber(ctx.idx.stringValue(n))
+			}
+			return sum
+		}},
+		"floor": {1, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return math.Floor(ctx.number(args[0].eval(ctx)))
+		}},
+		"ceiling": {1, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return math.Ceil(ctx.number(args[0].eval(ctx)))
+		}},
+		"round": {1, 1, func(ctx *xpathContext, args []xpathExpr) interface{} {
+			return xpathRound(ctx.number(args[0].eval(ctx)))
+		}},
+	}
+}
+
+// xpathMatches implements matches(input, pattern, flags?) with Go regular expressions. The flags 'i', 's' and 'm' are
+// supported.
+func xpathMatches(ctx *xpathContext, args []xpathExpr) interface{} {
+	pattern := ctx.stringArg(args, 1)
+	if len(args) > 2 {
+		flags := ctx.stringArg(args, 2)
+		for _, f := range flags {
+			if !strings.ContainsRune("ism", f) {
+				xpathPanic("invalid regular expression flag '%c'", f)
+			}
+		}
+		if flags != "" {
+			pattern = "(?" + flags + ")" + pattern
+		}
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		xpathPanic("invalid regular expression '%s'", ctx.stringArg(args, 1))
+	}
+	return re.MatchString(ctx.stringArg(args, 0))
+}
+
+// nodeSetArg evaluates an argument that must be a node-set
+func (ctx *xpathContext) nodeSetArg(arg xpathExpr) nodeSet {
+	s, ok := arg.eval(ctx).(nodeSet)
+	if !ok {
+		xpathPanic("argument is not a node-set")
+	}
+	return s
+}
+
+// stringArg evaluates the i'th argument as a string, defaulting to the context node when it was omitted
+func (ctx *xpathContext) stringArg(args []xpathExpr, i int) string {
+	if i >= len(args) {
+		return ctx.string(nodeSet{ctx.node})
+	}
+	return ctx.string(args[i].eval(ctx))
+}
+
+// nodeNameFunction returns a function that applies name to the first node of its argument, or the context node
+func nodeNameFunction(name func(ctx *xpathContext, n xnode) string) func(*xpathContext, []xpathExpr) interface{} {
+	return func(ctx *xpathContext, args []xpathExpr) interface{} {
+		s := nodeSet{ctx.node}
+		if len(args) > 0 {
+			s = ctx.nodeSetArg(args[0])
+		}
+		if len(s) == 0 {
+			return ""
+		}
+		return name(ctx, s[0])
+	}
+}
+
+// xpathID selects the elements with an 'id' or 'xml:id' attribute equal to any of the whitespace separated ids
+func xpathID(ctx *xpathContext, args []xpathExpr) interface{} {
+	var ids []string
+	if s, ok := args[0].eval(ctx).(nodeSet); ok {
+		for _, n := range s {
+			ids = append(ids, strings.Fields(ctx.idx.stringValue(n))...)
+		}
+	} else {
+		ids = strings.Fields(ctx.string(args[0].eval(ctx)))
+	}
+
+	want := map[string]bool{}
+	for _, id := range ids {
+		want[id] = true
+	}
+
+	var r nodeSet
+	for _, n := range ctx.descendants(xnode{kind: rootNode}) {
+		if n.kind != elementNode {
+			continue
+		}
+		for _, a := range n.tag.Attributes {
+			if a.Name == "id" && (a.Prefix == "" || a.Prefix == "xml") && want[a.Value] {
+				r = append(r, n)
+				break
+			}
+		}
+	}
+	return r
+}
+
+func xpathSubstring(ctx *xpathContext, args []xpathExpr) interface{} {
+	s := []rune(ctx.stringArg(args, 0))
+	start := xpathRound(ctx.number(args[1].eval(ctx)))
+	end := math.Inf(1)
+	if len(args) == 3 {
+		end = start + xpathRound(ctx.number(args[2].eval(ctx)))
+	}
+
+	var b strings.Builder
+	for i, r := range s {
+		if p := float64(i + 1); p >= start && p < end {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func xpathTranslate(ctx *xpathContext, args []xpathExpr) interface{} {
+	from := []rune(ctx.stringArg(args, 1))
+	to := []rune(ctx.stringArg(args, 2))
+
+	return strings.Map(func(r rune) rune {
+		for i, f := range from {
+			if f == r {
+				if i < len(to) {
+					return to[i]
+				}
+				return -1
+			}
+		}
+		return r
+	}, ctx.stringArg(args, 0))
+}
+
+// xpathLang returns true if the nearest xml:lang attribute of the context node is the given language or a
+// sublanguage of it
+func xpathLang(ctx *xpathContext, args []xpathExpr) interface{} {
+	lang := strings.ToLower(ctx.stringArg(args, 0))
+
+	for n, ok := ctx.node, true; ok; n, ok = ctx.idx.parentOf(n) {
+		if n.kind != elementNode {
+			continue
+		}
+		for _, a := range n.tag.Attributes {
+			if a.Prefix == "xml" && a.Name == "lang" {
+				v := strings.ToLower(a.Value)
+				return v == lang || strings.HasPrefix(v, lang+"-")
+			}
+		}
+	}
+	return false
+}
+
+// xpathRound rounds to the closest integer, rounding halves towards positive infinity
+func xpathRound(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return f
+	}
+	if f < 0 && f >= -0.5 {
+		return math.Copysign(0, -1)
+	}
+	return math.Floor(f + 0.5)
+}
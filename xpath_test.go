@@ -0,0 +1,173 @@
+package simplexml
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+
+	"strings"
+)
+
+const (
+	ExampleXPathXML = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:media="http://search.yahoo.com/mrss/">
+	<!-- items -->
+	<item id="1" type="video"><title>First</title><media:content url="a.mp4"/><price>1.5</price></item>
+	<item id="2" type="image"><title>Second</title><price>2</price></item>
+	<item id="3" type="video"><title>Third</title><media:content url="c.mp4"/><price>4</price></item>
+	<empty/>
+</feed>`
+)
+
+func TestXPath(t *testing.T) {
+	Convey("Given a Document from ExampleXPathXML", t, func() {
+		d, err := NewDocumentFromReader(strings.NewReader(ExampleXPathXML))
+		So(err, ShouldBeNil)
+		root := d.Root()
+
+		names := func(s Search) []string {
+			r := []string{}
+			for _, v := range s {
+				title := v
+				if v.Name == "item" {
+					title = v.Search().ByName("title").One()
+				}
+				val, _ := title.Value()
+				r = append(r, val)
+			}
+			return r
+		}
+
+		Convey("XPath() of a title should be its path from the root", func() {
+			title := root.Search().ByName("item").ByName("title").One()
+			So(title.XPath().String(), ShouldEqual, "/feed/item/title")
+		})
+
+		Convey("An attribute predicate should select by value", func() {
+			s, err := d.Query("//item[@id='3']/title")
+			So(err, ShouldBeNil)
+			So(names(s), ShouldResemble, []string{"Third"})
+		})
+
+		Convey("Positional predicates should follow the axis direction", func() {
+			s, err := root.Query("item[2]")
+			So(err, ShouldBeNil)
+			So(names(s), ShouldResemble, []string{"Second"})
+
+			s, err = root.Query("item[last()]/preceding-sibling::item[1]")
+			So(err, ShouldBeNil)
+			So(names(s), ShouldResemble, []string{"Second"})
+
+			s, err = root.Query("(//title)[position() > 1]")
+			So(err, ShouldBeNil)
+			So(names(s), ShouldResemble, []string{"Second", "Third"})
+		})
+
+		Convey("Functions should be available in predicates", func() {
+			s, err := d.Query("//item[contains(title, 'ir')]")
+			So(err, ShouldBeNil)
+			So(names(s), ShouldResemble, []string{"First", "Third"})
+
+			s, err = d.Query("//item[count(*) = 2]")
+			So(err, ShouldBeNil)
+			So(names(s), ShouldResemble, []string{"Second"})
+
+			s, err = d.Query("//title[text() = 'First']")
+			So(err, ShouldBeNil)
+			So(names(s), ShouldResemble, []string{"First"})
+
+			s, err = d.Query("//item[price > 1.75 and not(@type = 'image')]")
+			So(err, ShouldBeNil)
+			So(names(s), ShouldResemble, []string{"Third"})
+		})
+
+		Convey("Prefixed names should be resolved through GetNamespace", func() {
+			s, err := root.Query("item/media:content")
+			So(err, ShouldBeNil)
+			So(len(s), ShouldEqual, 2)
+
+			s, err = root.Query("//media:content/ancestor::item/@id/..")
+			So(err, ShouldBeNil)
+			So(names(s), ShouldResemble, []string{"First", "Third"})
+		})
+
+		Convey("The parent and ancestor axes should work from a nested Tag", func() {
+			title := root.Search().ByName("item").ByName("title").One()
+			s, err := title.Query("../following-sibling::item/title | /feed/item[1]/title")
+			So(err, ShouldBeNil)
+			So(names(s), ShouldResemble, []string{"First", "Second", "Third"})
+
+			s, err = title.Query("ancestor::*")
+			So(err, ShouldBeNil)
+			So(len(s), ShouldEqual, 2)
+			So(s[0].Name, ShouldEqual, "feed")
+		})
+
+		Convey("Evaluate should return atomic values and attribute nodes", func() {
+			v, err := MustCompile("sum(//price) div count(//item)").Evaluate(root)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 2.5)
+
+			v, err = MustCompile("string(item[@type='image']/title)").Evaluate(root)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, "Second")
+
+			v, err = MustCompile("//media:content/@url").Evaluate(root)
+			So(err, ShouldBeNil)
+			nodes := v.([]interface{})
+			So(len(nodes), ShouldEqual, 2)
+			So(nodes[1].(*Attribute).Value, ShouldEqual, "c.mp4")
+
+			v, err = MustCompile("concat(name(*[1]), '-', translate('abc', 'b', 'B'), '-', round(2.5), '-', substring('12345', 2, 3))").Evaluate(root)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, "item-aBc-3-234")
+
+			v, err = MustCompile("//comment()").Evaluate(root)
+			So(err, ShouldBeNil)
+			So(len(v.([]interface{})), ShouldEqual, 1)
+		})
+
+		Convey("QueryOne should return the first match or nil", func() {
+			tag, err := d.QueryOne("//empty")
+			So(err, ShouldBeNil)
+			So(tag, ShouldNotBeNil)
+
+			tag, err = d.QueryOne("//missing")
+			So(err, ShouldBeNil)
+			So(tag, ShouldBeNil)
+		})
+
+		Convey("Invalid expressions should return an *XPathError", func() {
+			_, err := Compile("//item[")
+			So(err, ShouldHaveSameTypeAs, &XPathError{})
+
+			_, err = Compile("unknown()")
+			So(err, ShouldNotBeNil)
+
+			_, err = root.Query("count(item)")
+			So(err, ShouldHaveSameTypeAs, &XPathError{})
+
+			_, err = root.Query("$var")
+			So(err, ShouldHaveSameTypeAs, &XPathError{})
+		})
+
+		Convey("Name matching extensions should tolerate differences in case and spelling", func() {
+			s, err := root.Query("*[lower-case(local-name()) = 'item']/*[upper-case(name()) = 'TITLE']")
+			So(err, ShouldBeNil)
+			So(names(s), ShouldResemble, []string{"First", "Second", "Third"})
+
+			s, err = root.Query("item[*[matches(local-name(), '^PRI', 'i')] > 1.5]")
+			So(err, ShouldBeNil)
+			So(names(s), ShouldResemble, []string{"Second", "Third"})
+
+			s, err = root.Query("*[glob(name(), 'e*y')]")
+			So(err, ShouldBeNil)
+			So(len(s), ShouldEqual, 1)
+
+			_, err = root.Query("item[matches(title, '(')]")
+			So(err, ShouldHaveSameTypeAs, &XPathError{})
+
+			_, err = root.Query("item[matches(title, 'a', 'q')]")
+			So(err, ShouldHaveSameTypeAs, &XPathError{})
+		})
+	})
+}